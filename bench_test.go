@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
 	"github.com/avive/rpost/prover"
 	"github.com/avive/rpost/util"
 	"github.com/stretchr/testify/assert"
@@ -55,3 +56,37 @@ func testProver(t *testing.T, n uint64, l uint, postFileName string, merkleFileN
 
 	assert.NoError(t, err)
 }
+
+func BenchmarkTableGeneration(b *testing.B) {
+	for _, w := range []uint{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", w), func(b *testing.B) {
+			benchmarkTableGeneration(b, 14, 8, w, "post_bench.bin")
+		})
+	}
+}
+
+func benchmarkTableGeneration(b *testing.B, n uint64, l uint, workers uint, postFileName string) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	f := filepath.Join(currFolder, postFileName)
+	id := util.Rnd1(32)
+	h := hashing.NewHashFunc(id)
+
+	for i := 0; i < b.N; i++ {
+		tbl, err := post.NewTable(id, n, l, h, f)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		tbl.SetWorkers(workers)
+
+		_, err = tbl.Generate(false)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
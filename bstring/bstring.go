@@ -82,7 +82,7 @@ func (f *SMBinaryStringFactory) NewBinaryStringFromInt(v uint64, d uint) (Binary
 		f: f,
 	}
 
-	m := f.cache[v][d]
+	m := f.cache[v]
 	if m == nil {
 		f.cache[v] = make(map[uint]*SMBinaryString, lengthCacheSize)
 	}
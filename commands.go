@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
+	"github.com/avive/rpost/prover"
+	"github.com/avive/rpost/verifier"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats supported by all commands
+const (
+	formatText = "text"
+	formatJson = "json"
+)
+
+type command func(ctx context.Context, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"init":    initCmd,
+	"prove":   proveCmd,
+	"verify":  verifyCmd,
+	"inspect": inspectCmd,
+}
+
+// Run the command named by args[0] with flags args[1:]
+func run(args []string, out io.Writer) error {
+	return runContext(context.Background(), args, out)
+}
+
+// Run the command named by args[0] with flags args[1:] until done or ctx is done
+// An interrupted init is resumed by running it again
+func runContext(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("missing command. usage: rpost <command> [flags]")
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(ctx, args[1:], out)
+}
+
+// Flags shared by commands
+type params struct {
+	id         string
+	n          uint64
+	l          uint
+	storeFile  string
+	merkleFile string
+	format     string
+	hash       string
+	verbose    bool
+}
+
+func newFlagSet(name string, p *params) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&p.id, "id", "", "hex encoded commitment id")
+	fs.Uint64Var(&p.n, "n", 0, "table size T = 2^n")
+	fs.UintVar(&p.l, "l", 0, "# of bits stored per table entry")
+	fs.StringVar(&p.storeFile, "store", "post.bin", "post store file")
+	fs.StringVar(&p.merkleFile, "merkle", "merkle.bin", "merkle tree file")
+	fs.StringVar(&p.format, "format", formatText, "output format: text or json")
+	fs.StringVar(&p.hash, "hash", "sha256", "hash backend: "+backendNames())
+	fs.BoolVar(&p.verbose, "v", false, "log debug output and progress to stderr")
+	return fs
+}
+
+// Returns the logger of the verbose flag. nil when not verbose
+func (p *params) logger() *log.Logger {
+	if !p.verbose {
+		return nil
+	}
+	return log.New(os.Stderr, "", log.LstdFlags)
+}
+
+// Validate the shared flags. Returns the decoded id
+func (p *params) validate() ([]byte, error) {
+	if p.format != formatText && p.format != formatJson {
+		return nil, fmt.Errorf("unsupported output format %q", p.format)
+	}
+
+	if p.id == "" {
+		return nil, errors.New("missing id")
+	}
+
+	id, err := hex.DecodeString(p.id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %v", err)
+	}
+
+	b, err := hashing.GetBackendByName(p.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = post.NewParams(id, p.n, p.l, b.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+// Returns Hx() for commitment id using the hash backend flag
+func (p *params) hashFunc(id []byte) (hashing.HashFunc, error) {
+	b, err := hashing.GetBackendByName(p.hash)
+	if err != nil {
+		return nil, err
+	}
+	return hashing.NewHashFuncWithBackend(id, b.Id)
+}
+
+// Returns the names of all registered hash backends
+func backendNames() string {
+	var names []string
+	for _, b := range hashing.Backends() {
+		names = append(names, b.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Add the table labeling flags to fs. Returns a func returning the labeling set by the flags
+func labelingFlags(fs *flag.FlagSet) func() (post.Labeling, error) {
+	mode := fs.String("labeling", "hash", "table entries labeling: hash, scrypt or argon2id")
+	scryptN := fs.Uint("scrypt-n", 1<<14, "scrypt CPU/memory cost N")
+	scryptR := fs.Uint("scrypt-r", 8, "scrypt block size r")
+	scryptP := fs.Uint("scrypt-p", 1, "scrypt parallelization p")
+	argon2Time := fs.Uint("argon2-time", 1, "argon2id # of passes")
+	argon2Memory := fs.Uint("argon2-memory", 64*1024, "argon2id memory in KiB")
+	argon2Threads := fs.Uint("argon2-threads", 1, "argon2id # of threads")
+
+	return func() (post.Labeling, error) {
+		var lb post.Labeling
+		switch *mode {
+		case "hash":
+		case "scrypt":
+			lb = post.NewScryptLabeling(uint32(*scryptN), uint32(*scryptR), uint32(*scryptP))
+		case "argon2id":
+			if *argon2Threads > 0xff {
+				return lb, errors.New("argon2id threads must be < 256")
+			}
+			lb = post.NewArgon2idLabeling(uint32(*argon2Time), uint32(*argon2Memory), uint8(*argon2Threads))
+		default:
+			return lb, fmt.Errorf("unknown labeling %q", *mode)
+		}
+		return lb, lb.Validate()
+	}
+}
+
+// Decode a required hex flag value
+func decodeHexFlag(name string, v string) ([]byte, error) {
+	if v == "" {
+		return nil, fmt.Errorf("missing %s", name)
+	}
+	res, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return res, nil
+}
+
+// A named output value
+type field struct {
+	name  string
+	value interface{}
+}
+
+// Print fields as a text table or as a json object
+func printFields(out io.Writer, format string, fields []field) error {
+	if format == formatJson {
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f.name] = f.value
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, f := range fields {
+		v := f.value
+		switch t := v.(type) {
+		case []string:
+			v = strings.Join(t, "\n\t")
+		}
+		fmt.Fprintf(w, "%s:\t%v\n", f.name, v)
+	}
+	return w.Flush()
+}
+
+// Generate the store and merkle tree files. Resumes a previously interrupted init
+func initCmd(ctx context.Context, args []string, out io.Writer) error {
+	p := &params{}
+	fs := newFlagSet("init", p)
+	workers := fs.Uint("workers", 1, "# of table generation workers")
+	omit := fs.Uint("omit-levels", 0, "# of lowest merkle tree levels recomputed from the store instead of being stored")
+	layout := fs.String("layout", post.LayoutDepthFirst.String(), "merkle tree file layout: depth-first or level-order")
+	manifest := fs.String("manifest", "", "shard manifest file. Store and merkle tree are split into shards when set")
+	shardBits := fs.Uint("shard-bits", 0, "split the table into 2^shard-bits shards")
+	dirs := fs.String("dirs", ".", "comma separated shard directories. Relative to the manifest directory")
+	labeling := labelingFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	id, err := p.validate()
+	if err != nil {
+		return err
+	}
+
+	lb, err := labeling()
+	if err != nil {
+		return err
+	}
+
+	h, err := p.hashFunc(id)
+	if err != nil {
+		return err
+	}
+
+	var table *post.Table
+	if *manifest != "" {
+		var m *post.Manifest
+		m, err = post.NewManifest(p.n, p.l, *shardBits, strings.Split(*dirs, ","))
+		if err != nil {
+			return err
+		}
+		table, err = post.OpenShardedTable(id, p.n, p.l, h, lb, m, *manifest)
+	} else {
+		table, err = post.OpenTableWithLabeling(id, p.n, p.l, h, lb, p.storeFile)
+	}
+	if err != nil {
+		return err
+	}
+	table.SetWorkers(*workers)
+
+	if lg := p.logger(); lg != nil {
+		table.SetLogger(lg)
+		table.SetProgress(func(pr post.Progress) {
+			lg.Printf("%d / %d entries. %.0f hashes/s. ETA %s", pr.Entries, pr.Total, pr.HashRate, pr.ETA.Round(time.Second))
+		})
+	}
+
+	err = table.SetOmittedLevels(*omit)
+	if err != nil {
+		return err
+	}
+
+	tl, err := post.ParseTreeLayout(*layout)
+	if err != nil {
+		return err
+	}
+	table.SetMerkleLayout(tl)
+
+	if *manifest != "" {
+		comm, err := table.StoreShardedContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		return printFields(out, p.format, []field{
+			{"manifest", *manifest},
+			{"commitment", hex.EncodeToString(comm)},
+		})
+	}
+
+	comm, err := table.StoreContext(ctx, p.merkleFile)
+	if err != nil {
+		return err
+	}
+
+	return printFields(out, p.format, []field{
+		{"store", p.storeFile},
+		{"merkle", p.merkleFile},
+		{"commitment", hex.EncodeToString(comm)},
+	})
+}
+
+// Create a proof for a challenge and write it to a file
+func proveCmd(ctx context.Context, args []string, out io.Writer) error {
+	p := &params{}
+	fs := newFlagSet("prove", p)
+	challengeHex := fs.String("challenge", "", "hex encoded challenge")
+	proofFile := fs.String("proof", "proof.bin", "output proof file")
+	mmap := fs.Bool("mmap", false, "read the store and merkle tree files through memory mappings")
+	manifest := fs.String("manifest", "", "shard manifest file of a sharded table. Overrides -store and -merkle")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	id, err := p.validate()
+	if err != nil {
+		return err
+	}
+
+	challenge, err := decodeHexFlag("challenge", *challengeHex)
+	if err != nil {
+		return err
+	}
+
+	h, err := p.hashFunc(id)
+	if err != nil {
+		return err
+	}
+
+	var opts []post.ReaderOption
+	if *mmap {
+		opts = append(opts, post.WithMmap())
+	}
+
+	pp, err := post.NewParams(id, p.n, p.l, h.Id())
+	if err != nil {
+		return err
+	}
+
+	var pv prover.Prover
+	if *manifest != "" {
+		pv, err = prover.NewShardedProver(pp, *manifest, opts...)
+	} else {
+		pv, err = prover.NewProverWithParams(pp, p.storeFile, p.merkleFile, opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if lg := p.logger(); lg != nil {
+		pv.SetLogger(lg)
+		pv.SetProgress(func(pr post.Progress) {
+			lg.Printf("%d / %d nonces found. %d attempts", pr.Entries, pr.Total, pr.Attempts)
+		})
+	}
+
+	proof, err := pv.ProveContext(ctx, challenge)
+	if err != nil {
+		return err
+	}
+
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(*proofFile, data, 0666)
+	if err != nil {
+		return err
+	}
+
+	return printFields(out, p.format, []field{
+		{"proof", *proofFile},
+		{"size", len(data)},
+	})
+}
+
+// Verify a proof file. Returns an error if the proof is invalid
+// Without labeling flags the table labeling is read from the merkle file header or the manifest shards
+func verifyCmd(ctx context.Context, args []string, out io.Writer) error {
+	p := &params{}
+	fs := newFlagSet("verify", p)
+	challengeHex := fs.String("challenge", "", "hex encoded challenge")
+	commitmentHex := fs.String("commitment", "", "hex encoded merkle commitment. Read from the merkle file header or the manifest when not set")
+	manifest := fs.String("manifest", "", "shard manifest file to read the commitment from")
+	proofFile := fs.String("proof", "proof.bin", "proof file")
+	labeling := labelingFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	id, err := p.validate()
+	if err != nil {
+		return err
+	}
+
+	lb, err := labeling()
+	if err != nil {
+		return err
+	}
+
+	challenge, err := decodeHexFlag("challenge", *challengeHex)
+	if err != nil {
+		return err
+	}
+
+	h, err := p.hashFunc(id)
+	if err != nil {
+		return err
+	}
+
+	// labeling of the table files the commitment is read from. nil when the commitment is set by a flag
+	var commitment []byte
+	var tableLb *post.Labeling
+	if *commitmentHex != "" {
+		commitment, err = decodeHexFlag("commitment", *commitmentHex)
+	} else if *manifest != "" {
+		commitment, tableLb, err = readManifestCommitment(*manifest)
+	} else {
+		commitment, tableLb, err = readCommitment(p.merkleFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(*proofFile)
+	if err != nil {
+		return err
+	}
+
+	proof := &prover.Proof{}
+	err = proof.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+
+	if proof.N != p.n || proof.L != p.l {
+		return fmt.Errorf("proof was created for n=%d l=%d", proof.N, proof.L)
+	}
+
+	// without labeling flags the table labeling is the one of the table files or the default hash labeling
+	// The labeling recorded in the proof is never trusted - a prover could pick a cheaper one
+	if !isFlagSet(fs, "labeling") && tableLb != nil {
+		lb = *tableLb
+	}
+
+	err = verifier.VerifyWithLabeling(id, challenge, commitment, p.n, p.l, h, lb, proof)
+	if err != nil {
+		return fmt.Errorf("invalid proof: %v", err)
+	}
+
+	return printFields(out, p.format, []field{
+		{"proof", *proofFile},
+		{"labeling", lb.String()},
+		{"valid", true},
+	})
+}
+
+// Returns true iff the flag name was set on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Read the commitment and the table labeling from a merkle tree file header
+func readCommitment(merkleFile string) ([]byte, *post.Labeling, error) {
+	h, err := post.ReadMerkleHeader(merkleFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", merkleFile, err)
+	}
+	if !h.HasCommitment() {
+		return nil, nil, fmt.Errorf("%s: merkle tree is not fully written", merkleFile)
+	}
+	return h.Commitment[:], &h.Labeling, nil
+}
+
+// Read the commitment from a shard manifest and the table labeling from its shards
+func readManifestCommitment(manifestFile string) ([]byte, *post.Labeling, error) {
+	m, err := post.ReadManifest(manifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Commitment == nil {
+		return nil, nil, fmt.Errorf("%s: merkle tree is not fully written", manifestFile)
+	}
+	lb, err := m.Labeling()
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Commitment, &lb, nil
+}
+
+// Print the store header and optionally a store entry and its merkle path
+// id, n and l default to the values in the store header
+func inspectCmd(ctx context.Context, args []string, out io.Writer) error {
+	p := &params{}
+	fs := newFlagSet("inspect", p)
+	index := fs.Int64("index", -1, "store entry index to print with its merkle path. None when negative")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if p.format != formatText && p.format != formatJson {
+		return fmt.Errorf("unsupported output format %q", p.format)
+	}
+
+	h, err := post.ReadStoreHeader(p.storeFile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.storeFile, err)
+	}
+
+	if p.n == 0 {
+		p.n = h.N
+	}
+	if p.l == 0 {
+		p.l = h.L
+	}
+
+	b, err := hashing.GetBackend(h.HashId)
+	if err != nil {
+		return err
+	}
+
+	fields := []field{
+		{"store", p.storeFile},
+		{"version", h.Version},
+		{"n", h.N},
+		{"l", h.L},
+		{"hash", b.Name},
+		{"labeling", h.Labeling.String()},
+		{"id_hash", hex.EncodeToString(h.IdHash[:])},
+		{"commitment", hex.EncodeToString(h.Commitment[:])},
+	}
+
+	// Hx() is needed to recompute omitted merkle tree labels
+	var hf hashing.HashFunc
+	if p.id != "" {
+		id, err := p.validate()
+		if err != nil {
+			return err
+		}
+		hf, err = p.hashFunc(id)
+		if err != nil {
+			return err
+		}
+		err = h.Validate(id, p.n, p.l, hf.Id())
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.storeFile, err)
+		}
+	}
+
+	if *index >= 0 {
+		idx := uint64(*index)
+		if idx >= 1<<h.N {
+			return fmt.Errorf("index must be smaller than table size %d", uint64(1)<<h.N)
+		}
+
+		entry, path, err := readEntry(p.storeFile, p.merkleFile, h, hf, idx)
+		if err != nil {
+			return err
+		}
+
+		fields = append(fields,
+			field{"index", idx},
+			field{"entry", entry},
+			field{"merkle_path", path})
+	}
+
+	return printFields(out, p.format, fields)
+}
+
+// Returns the bits of the store entry at idx, msb first, and the merkle path from the entry to the root
+// Each path node is formatted as node id and hex encoded label
+// hf may be nil if the merkle tree file has no omitted levels
+func readEntry(storeFile string, merkleFile string, h *post.Header, hf hashing.HashFunc, idx uint64) (string, []string, error) {
+
+	sr, mr, err := post.OpenTableReaders(storeFile, merkleFile, hf)
+	if err != nil {
+		return "", nil, err
+	}
+	defer sr.Close()
+	defer mr.Close()
+
+	v, err := sr.ReadUint64(idx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mps, err := mr.ReadProofs([]*big.Int{new(big.Int).SetUint64(idx)})
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := make([]string, len(mps[0]))
+	for i, node := range mps[0] {
+		path[i] = fmt.Sprintf("%s %s", node.Id, hex.EncodeToString(node.Label))
+	}
+
+	return fmt.Sprintf("%0*b", h.L, v), path, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+
+	dir := t.TempDir()
+	f := filepath.Join(dir, "post_cli.bin")
+	mf := filepath.Join(dir, "merkle_cli.bin")
+	pf := filepath.Join(dir, "proof_cli.bin")
+
+	id := hex.EncodeToString(util.Rnd(t, 32))
+	challenge := hex.EncodeToString(util.Rnd(t, 32))
+	common := []string{"-id", id, "-n", "9", "-l", "4", "-store", f, "-merkle", mf, "-format", "json"}
+
+	out := &bytes.Buffer{}
+	err := run(append([]string{"init"}, common...), out)
+	assert.NoError(t, err)
+
+	res := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	comm := res["commitment"]
+
+	out.Reset()
+	err = run(append([]string{"prove", "-challenge", challenge, "-proof", pf}, common...), out)
+	assert.NoError(t, err)
+
+	// commitment is read from the merkle file header
+	out.Reset()
+	err = run(append([]string{"verify", "-challenge", challenge, "-proof", pf}, common...), out)
+	assert.NoError(t, err)
+
+	res = make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, true, res["valid"])
+	assert.Equal(t, "hash", res["labeling"])
+
+	err = run(append([]string{"verify", "-challenge", challenge, "-proof", pf, "-labeling", "scrypt", "-scrypt-n", "16"}, common...), out)
+	assert.Error(t, err, "expected proof to be invalid for another labeling")
+
+	err = run(append([]string{"verify", "-challenge", hex.EncodeToString(util.Rnd(t, 32)), "-proof", pf}, common...), out)
+	assert.Error(t, err, "expected proof to be invalid for another challenge")
+
+	err = run(append([]string{"verify", "-challenge", challenge, "-proof", pf, "-commitment", hex.EncodeToString(util.Rnd(t, 32))}, common...), out)
+	assert.Error(t, err, "expected proof to be invalid for another commitment")
+
+	out.Reset()
+	err = run([]string{"inspect", "-store", f, "-merkle", mf, "-index", "17", "-format", "json"}, out)
+	assert.NoError(t, err)
+
+	res = make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, comm, res["commitment"])
+	assert.Len(t, res["entry"], 4)
+	assert.Len(t, res["merkle_path"], 9)
+
+	// text output
+	out.Reset()
+	err = run([]string{"inspect", "-store", f, "-merkle", mf, "-index", "17"}, out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), comm)
+
+	err = run([]string{"inspect", "-store", f, "-id", hex.EncodeToString(util.Rnd(t, 32))}, out)
+	assert.Error(t, err, "expected inspect to fail for another id")
+
+	err = run([]string{"unknown"}, out)
+	assert.Error(t, err)
+
+	err = run(nil, out)
+	assert.Error(t, err)
+}
+
+// The labeling a proof is verified against is never the one recorded in the proof
+func TestVerifyLabeling(t *testing.T) {
+
+	dir := t.TempDir()
+	f := filepath.Join(dir, "post_cli.bin")
+	mf := filepath.Join(dir, "merkle_cli.bin")
+	pf := filepath.Join(dir, "proof_cli.bin")
+
+	id := hex.EncodeToString(util.Rnd(t, 32))
+	challenge := hex.EncodeToString(util.Rnd(t, 32))
+	common := []string{"-id", id, "-n", "9", "-l", "2", "-store", f, "-merkle", mf, "-format", "json"}
+	scrypt := []string{"-labeling", "scrypt", "-scrypt-n", "16"}
+
+	out := &bytes.Buffer{}
+	err := run(append(append([]string{"init"}, scrypt...), common...), out)
+	assert.NoError(t, err)
+
+	res := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	comm := res["commitment"].(string)
+
+	err = run(append([]string{"prove", "-challenge", challenge, "-proof", pf}, common...), out)
+	assert.NoError(t, err)
+
+	// the labeling is read from the merkle file header
+	out.Reset()
+	err = run(append([]string{"verify", "-challenge", challenge, "-proof", pf}, common...), out)
+	assert.NoError(t, err)
+	res = make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, true, res["valid"])
+	assert.NotEqual(t, "hash", res["labeling"])
+
+	// a commitment flag without a labeling flag expects the hash labeling, not the scrypt labeling of the proof
+	err = run(append([]string{"verify", "-challenge", challenge, "-proof", pf, "-commitment", comm}, common...), out)
+	assert.Error(t, err, "expected proof to be invalid for the hash labeling")
+
+	out.Reset()
+	err = run(append(append([]string{"verify", "-challenge", challenge, "-proof", pf, "-commitment", comm}, scrypt...),
+		common...), out)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,103 @@
+package hashing
+
+import (
+	"hash"
+)
+
+// HashFunc implementation over any registered hash backend
+type hashFunc struct {
+	x          []byte // arbitrary binary data
+	hash       hash.Hash
+	b          *Backend
+	iters      int
+	emptySlice []byte
+}
+
+// Returns a new HashFunc Hx() for commitment x using the default SHA-256 backend
+func NewHashFunc(x []byte) HashFunc {
+	h, err := NewHashFuncWithBackend(x, SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// Returns a new HashFunc Hx() for commitment x using the registered backend with the provided id
+func NewHashFuncWithBackend(x []byte, id byte) (HashFunc, error) {
+	b, err := GetBackend(id)
+	if err != nil {
+		return nil, err
+	}
+
+	iters := b.Iters
+	if iters == 0 {
+		iters = DefaultIters
+	}
+
+	return &hashFunc{x: x, hash: b.New(), b: b, iters: iters}, nil
+}
+
+// Returns the id of the hash backend
+func (h *hashFunc) Id() byte {
+	return h.b.Id
+}
+
+// Returns the output size in bytes
+func (h *hashFunc) Size() int {
+	return h.b.Size
+}
+
+// Hash implements Hx()
+func (h *hashFunc) HashSlices(data [][]byte) []byte {
+	h.hash.Reset()
+	h.hash.Write(h.x)
+	for _, d := range data {
+		_, _ = h.hash.Write(d)
+	}
+
+	return h.hash.Sum([]byte{})
+}
+
+// Hash implements Hx()
+func (h *hashFunc) Hash(data ...[]byte) []byte {
+	h.hash.Reset()
+	h.hash.Write(h.x)
+	for _, d := range data {
+		_, _ = h.hash.Write(d)
+	}
+
+	return h.hash.Sum([]byte{})
+}
+
+// Multiple iterations hash using the backend iters
+func (h *hashFunc) HashIters(data ...[]byte) []byte {
+
+	h.hash.Reset()
+
+	// first, hash x
+	h.hash.Write(h.x)
+
+	// hash all user provided data
+	for _, d := range data {
+		_, _ = h.hash.Write(d)
+	}
+
+	digest := h.hash.Sum([]byte{})
+
+	// perform iter hashes of x and user data
+	for i := 0; i < h.iters; i++ {
+		h.hash.Reset()
+		h.hash.Write(h.x)
+		h.hash.Write(digest)
+		digest = h.hash.Sum(h.emptySlice)
+	}
+
+	return digest
+}
+
+func (h *hashFunc) HashSingle(data []byte) []byte {
+	h.hash.Reset()
+	h.hash.Write(h.x)
+	h.hash.Write(data)
+	return h.hash.Sum([]byte{})
+}
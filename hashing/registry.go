@@ -0,0 +1,96 @@
+package hashing
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	simd "github.com/minio/sha256-simd" // simd optimized sha256 computation
+	"golang.org/x/crypto/blake2b"
+	"hash"
+	"sort"
+)
+
+// Hash backend ids recorded in post store and merkle tree file headers
+const (
+	SHA256     byte = 1 // simd optimized SHA-256
+	SHA512_256 byte = 2 // SHA-512/256
+	BLAKE2b256 byte = 3 // BLAKE2b-256
+	SHA256Go   byte = 4 // pure go stdlib SHA-256. Produces the same digests as SHA256
+)
+
+// Output size in bytes of every hash backend. Commitments are stored as Size bytes in file headers and manifests and
+// the protocol params K and WB are derived from it, so backends of other output sizes are not supported
+const Size = 32
+
+// Default # of iterations of HashIters()
+const DefaultIters = 50
+
+// Backend is a named hash function implementation Hx() can be built on
+type Backend struct {
+	Id    byte
+	Name  string
+	Size  int // output size in bytes. Must be Size
+	New   func() hash.Hash
+	Iters int // # of iterations of HashIters(). DefaultIters when 0
+}
+
+var backends = make(map[byte]*Backend)
+
+func init() {
+	Register(&Backend{SHA256, "sha256", simd.Size, simd.New, DefaultIters})
+	Register(&Backend{SHA512_256, "sha512/256", sha512.Size256, sha512.New512_256, DefaultIters})
+	Register(&Backend{BLAKE2b256, "blake2b-256", blake2b.Size256, newBlake2b256, DefaultIters})
+	Register(&Backend{SHA256Go, "sha256-go", sha256.Size, sha256.New, DefaultIters})
+}
+
+// Register a hash backend. Panics if a backend with the same id or name is already registered or if its
+// output size isn't Size
+func Register(b *Backend) {
+	if b.Size != Size {
+		panic(fmt.Sprintf("hash backend %q output size must be %d bytes", b.Name, Size))
+	}
+	for _, r := range backends {
+		if r.Id == b.Id || r.Name == b.Name {
+			panic(fmt.Sprintf("hash backend %d %q is already registered", b.Id, b.Name))
+		}
+	}
+	backends[b.Id] = b
+}
+
+// Returns the registered backend with the provided id
+func GetBackend(id byte) (*Backend, error) {
+	b, ok := backends[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash backend id %d", id)
+	}
+	return b, nil
+}
+
+// Returns the registered backend with the provided name
+func GetBackendByName(name string) (*Backend, error) {
+	for _, b := range backends {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown hash backend %q", name)
+}
+
+// Returns all registered backends ordered by id
+func Backends() []*Backend {
+	res := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return res
+}
+
+func newBlake2b256() hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		// only returned for keys longer than 64 bytes
+		panic(err)
+	}
+	return h
+}
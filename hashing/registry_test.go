@@ -0,0 +1,62 @@
+package hashing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+
+	// digests of "abc"
+	vectors := map[byte]string{
+		SHA256:     "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		SHA512_256: "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+		BLAKE2b256: "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		SHA256Go:   "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+
+	assert.Len(t, Backends(), len(vectors))
+
+	for _, b := range Backends() {
+		h, err := NewHashFuncWithBackend(nil, b.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, b.Id, h.Id())
+		assert.Equal(t, Size, h.Size())
+
+		d := h.Hash([]byte("abc"))
+		assert.Equal(t, vectors[b.Id], hex.EncodeToString(d), b.Name)
+		assert.Len(t, d, h.Size())
+
+		b1, err := GetBackendByName(b.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, b, b1)
+	}
+
+	_, err := NewHashFuncWithBackend(nil, 0)
+	assert.Error(t, err)
+	_, err = GetBackendByName("md5")
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { Register(&Backend{SHA256, "sha256-other", Size, nil, 0}) })
+	assert.Panics(t, func() { Register(&Backend{0xff, "sha512", 64, nil, 0}) })
+}
+
+func TestBackendIters(t *testing.T) {
+
+	Register(&Backend{0xfe, "sha256-iters", Size, sha256.New, 1})
+	defer delete(backends, 0xfe)
+
+	x := []byte("x")
+	h, err := NewHashFuncWithBackend(x, 0xfe)
+	assert.NoError(t, err)
+
+	// a single iteration hashes the digest of the data once more
+	d := h.Hash([]byte("abc"))
+	assert.Equal(t, h.Hash(d), h.(*hashFunc).HashIters([]byte("abc")))
+
+	h, err = NewHashFuncWithBackend(x, SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultIters, h.(*hashFunc).iters)
+}
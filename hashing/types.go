@@ -1,8 +1,10 @@
 package hashing
 
 type HashFunc interface {
-	// Hash takes arbitrary binary data and returns WB bytes
+	// Hash takes arbitrary binary data and returns Size() bytes
 	Hash(data ...[]byte) []byte
 	HashSlices(data [][]byte) []byte
 	HashSingle(data []byte) []byte
+	Id() byte  // id of the hash backend. Recorded in post store and merkle tree file headers
+	Size() int // output size in bytes
 }
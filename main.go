@@ -1,15 +1,34 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"syscall"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
+const usage = `usage: rpost [-cpuprofile file] <command> [flags]
+
+commands:
+  init     generate a post store and its merkle tree
+  prove    create a proof for a challenge
+  verify   verify a proof
+  inspect  print store and merkle tree files info
+
+run 'rpost <command> -h' for command flags
+`
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -19,4 +38,25 @@ func main() {
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// an interrupt stops the command. Files are left consistent so an interrupted init can be resumed
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	err := runContext(ctx, flag.Args(), os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rpost: %v\n", err)
+		pprof.StopCPUProfile()
+		os.Exit(1)
+	}
 }
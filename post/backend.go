@@ -0,0 +1,183 @@
+package post
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backend is the random access storage of a post store or a merkle tree store
+// e.g. a file, a block device, an object-store shim, an encrypted volume or a test double
+// A store owns its backend and closes it when the store is closed
+type Backend interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)      // size of the stored data in bytes
+	Truncate(size int64) error // change the size of the stored data
+	Close() error
+	Name() string // name of the storage. Returned by FileName() of stores. e.g. a file path
+}
+
+// A backend which storage can be deleted
+type remover interface {
+	Remove() error
+}
+
+// A backend which content can be atomically replaced
+type replacer interface {
+	Replace(data []byte) error
+}
+
+var errMmapBackend = errors.New("memory mapping is only supported for file backends")
+
+// A file backend
+type fileBackend struct {
+	*os.File
+}
+
+// Returns a backend storing data in f
+func NewFileBackend(f *os.File) Backend {
+	return &fileBackend{f}
+}
+
+// Open the file at filePath with flag as a backend
+func openFileBackend(filePath string, flag int) (Backend, error) {
+	f, err := os.OpenFile(filePath, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileBackend(f), nil
+}
+
+func (b *fileBackend) Size() (int64, error) {
+	fi, err := b.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (b *fileBackend) Remove() error {
+	return os.Remove(b.File.Name())
+}
+
+// Replace the file with a new file holding data
+// data is written and synced to a temp file first and the rename is synced so a crash leaves either the old or the
+// new file content
+func (b *fileBackend) Replace(data []byte) error {
+	fileName := b.File.Name()
+	tmpFileName := fileName + ".tmp"
+
+	err := writeFileSync(tmpFileName, data)
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+
+	err = os.Rename(tmpFileName, fileName)
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(filepath.Dir(fileName))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+
+	b.File.Close()
+	b.File = f
+	return nil
+}
+
+// Write data to the file fileName and sync it to the storage
+func writeFileSync(fileName string, data []byte) error {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Sync the directory dir so renames of its files are persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// Returns the file of a file backend
+func backendFile(b Backend) (*os.File, error) {
+	fb, ok := b.(*fileBackend)
+	if !ok {
+		return nil, errMmapBackend
+	}
+	return fb.File, nil
+}
+
+// Delete the storage of b. A backend which can't be removed is truncated
+func removeBackend(b Backend) error {
+	if r, ok := b.(remover); ok {
+		return r.Remove()
+	}
+	return b.Truncate(0)
+}
+
+// Replace the content of b with data. Backends which content can't be atomically replaced are overwritten
+func replaceBackend(b Backend, data []byte) error {
+	if r, ok := b.(replacer); ok {
+		return r.Replace(data)
+	}
+
+	_, err := b.WriteAt(data, 0)
+	if err != nil {
+		return err
+	}
+	return b.Truncate(int64(len(data)))
+}
+
+// A backend which Close() doesn't close the wrapped backend
+// Used to keep a backend open after closing a store reading or writing it
+type nopCloseBackend struct {
+	Backend
+}
+
+func (nopCloseBackend) Close() error {
+	return nil
+}
+
+// io.Writer writing to a backend from an offset
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,292 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// An in-memory backend test double
+type memBackend struct {
+	mu     sync.Mutex
+	data   []byte
+	closed bool
+}
+
+func (b *memBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *memBackend) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	return copy(b.data[off:], p), nil
+}
+
+func (b *memBackend) Size() (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.data)), nil
+}
+
+func (b *memBackend) Truncate(size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size <= int64(len(b.data)) {
+		b.data = b.data[:size]
+	} else {
+		b.data = append(b.data, make([]byte, size-int64(len(b.data)))...)
+	}
+	return nil
+}
+
+func (b *memBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *memBackend) Name() string {
+	return "mem"
+}
+
+// A backend test double which runs out of space after limit bytes
+type fullBackend struct {
+	memBackend
+	limit int64
+}
+
+func (b *fullBackend) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > b.limit {
+		return 0, syscall.ENOSPC
+	}
+	return b.memBackend.WriteAt(p, off)
+}
+
+func TestStorageBackends(t *testing.T) {
+
+	const n = uint64(9)
+	const l = uint(13)
+	hdr := NewHeader(util.Rnd(t, 32), n, l, hashing.SHA256)
+
+	// store
+	b := &memBackend{}
+	w, err := NewStoreWriterWithBackend(b, hdr)
+	assert.NoError(t, err)
+
+	entries := make([]uint64, 1<<n)
+	for i := range entries {
+		entries[i] = uint64(rand.Intn(1 << l))
+		assert.NoError(t, w.Write(entries[i], byte(l)))
+	}
+	assert.NoError(t, w.Close())
+	assert.True(t, b.closed)
+	assert.Equal(t, "mem", w.FileName())
+
+	sr, err := newStoreReaderWithBackend(b, l)
+	assert.NoError(t, err)
+
+	indices := []uint64{511, 0, 7, 300, 8}
+	values, err := sr.ReadUint64Batch(indices)
+	assert.NoError(t, err)
+	for i, idx := range indices {
+		assert.Equal(t, entries[idx], values[i])
+		v, err := sr.ReadUint64(idx)
+		assert.NoError(t, err)
+		assert.Equal(t, entries[idx], v)
+	}
+
+	_, err = newStoreReaderWithBackend(b, l, WithMmap())
+	assert.Equal(t, errMmapBackend, err)
+
+	// tree stores of both layouts
+	for _, layout := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		hdr.Layout = layout
+
+		var ids []Identifier
+		postOrderIds(uint(n-1), 0, rootId, &ids)
+
+		labels := make(map[Identifier]Label, len(ids))
+		for _, id := range ids {
+			labels[id] = util.Rnd(t, 32)
+		}
+
+		tb := &memBackend{}
+		tw, err := NewTreeStoreWriterWithBackend(tb, hdr)
+		assert.NoError(t, err)
+		for _, id := range ids {
+			assert.NoError(t, tw.Write(id, labels[id]))
+		}
+		assert.NoError(t, tw.Close())
+
+		tr, err := NewTreeStoreReaderWithBackend(tb, uint(n-1))
+		assert.NoError(t, err)
+		for _, id := range ids {
+			label, err := tr.Read(id)
+			assert.NoError(t, err, layout.String())
+			assert.Equal(t, labels[id], label, layout.String())
+		}
+
+		// a backend which can't be removed is truncated
+		tw, err = OpenTreeStoreWriterWithBackend(tb, hdr, uint64(len(ids)))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Delete())
+		size, _ := tb.Size()
+		assert.Equal(t, int64(0), size, layout.String())
+	}
+}
+
+// A table and its merkle tree are written to backends only
+func TestTableBackends(t *testing.T) {
+
+	const n, l = uint64(10), uint(6)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+	p, err := NewParams(id, n, l, h.Id())
+	assert.NoError(t, err)
+
+	table, err := NewMemoryTable(id, n, l, h, Labeling{}, NewMemoryStore(l))
+	assert.NoError(t, err)
+	comm, _, err := table.StoreInMemory()
+	assert.NoError(t, err)
+
+	sb, cb, mb := &memBackend{}, &memBackend{}, &memBackend{}
+	table, err = NewTableWithBackends(p, Labeling{}, sb, cb)
+	assert.NoError(t, err)
+	comm1, err := table.StoreWithBackend(context.Background(), mb)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+	assert.True(t, sb.closed && cb.closed && mb.closed)
+
+	// the commitment is in both headers and in the checkpoint
+	for _, hb := range []struct {
+		b     *memBackend
+		magic [4]byte
+	}{{sb, storeMagic}, {mb, merkleMagic}} {
+		hdr, err := readHeader(hb.b, hb.magic)
+		assert.NoError(t, err)
+		assert.Equal(t, comm, hdr.Commitment[:])
+	}
+
+	cp, err := readCheckpoint(cb)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, cp.Commitment)
+	assert.Equal(t, uint64(1)<<n, cp.Entries)
+
+	// a fully initialized table is not regenerated
+	table, err = OpenTableWithBackends(p, Labeling{}, sb, cb)
+	assert.NoError(t, err)
+	comm1, err = table.StoreWithBackend(context.Background(), mb)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+
+	// backends with no table are a new table
+	_, err = OpenTableWithBackends(p, Labeling{}, &memBackend{}, &memBackend{})
+	assert.NoError(t, err)
+
+	_, err = OpenTableWithBackends(p, NewScryptLabeling(16, 1, 1), sb, cb)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+}
+
+func TestTreeStoreOutOfSpace(t *testing.T) {
+
+	const n = uint64(9)
+	const l = uint(8)
+	id := util.Rnd(t, 32)
+	hdr := NewHeader(id, n, l, hashing.SHA256)
+
+	ms := NewMemoryStore(l)
+	for i := 0; i < 1<<n; i++ {
+		assert.NoError(t, ms.Write(uint64(rand.Intn(1<<l)), byte(l)))
+	}
+
+	for _, layout := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		hdr.Layout = layout
+
+		// room for the header and a few labels
+		b := &fullBackend{limit: HeaderSize + 4*32}
+		w, err := NewTreeStoreWriterWithBackend(b, hdr)
+		assert.NoError(t, err)
+
+		mw := NewMerkleTreeWriterWithStore(ms, w, hdr, hashing.NewHashFunc(id))
+		_, err = mw.Write()
+		assert.Equal(t, syscall.ENOSPC, err, layout.String())
+		assert.True(t, b.closed, layout.String())
+	}
+}
+
+// Running out of space in the post store or the tree store fails the table store phase and closes its backends
+func TestTableOutOfSpace(t *testing.T) {
+
+	const n, l = uint64(10), uint(8)
+
+	id := util.Rnd(t, 32)
+	p, err := NewParams(id, n, l, hashing.SHA256)
+	assert.NoError(t, err)
+
+	for _, workers := range []uint{1, 4} {
+		// room for the headers and a few entries or labels
+		sb, mb := &fullBackend{limit: HeaderSize + 64}, &memBackend{}
+		cb := &memBackend{}
+		table, err := NewTableWithBackends(p, Labeling{}, sb, cb)
+		assert.NoError(t, err)
+		table.SetWorkers(workers)
+		_, err = table.StoreWithBackend(context.Background(), mb)
+		assert.True(t, errors.Is(err, syscall.ENOSPC), "store: %v", err)
+		assert.True(t, sb.closed && cb.closed && mb.closed, "store")
+
+		ms, fb := &memBackend{}, &fullBackend{limit: HeaderSize + 4*32}
+		cb = &memBackend{}
+		table, err = NewTableWithBackends(p, Labeling{}, ms, cb)
+		assert.NoError(t, err)
+		table.SetWorkers(workers)
+		_, err = table.StoreWithBackend(context.Background(), fb)
+		assert.True(t, errors.Is(err, syscall.ENOSPC), "tree store: %v", err)
+		assert.True(t, ms.closed && cb.closed && fb.closed, "tree store")
+	}
+}
+
+func TestFileBackendReplace(t *testing.T) {
+
+	f := filepath.Join(t.TempDir(), "replace.meta")
+	b, err := openFileBackend(f, os.O_RDWR|os.O_CREATE)
+	assert.NoError(t, err)
+	_, err = b.WriteAt([]byte("old content"), 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, replaceBackend(b, []byte("new")))
+
+	// the backend reads the new file and no temp file is left
+	size, err := b.Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	data, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), data)
+	_, err = os.Stat(f + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, b.Close())
+}
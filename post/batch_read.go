@@ -0,0 +1,95 @@
+package post
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	// max # of bytes between 2 requests read together in one range
+	batchReadGap = 4096
+
+	// max # of concurrent range reads
+	batchReadWorkers = 16
+)
+
+// A read of size bytes at a file offset
+type readRequest struct {
+	off  int64
+	size int
+}
+
+// A coalesced range of sorted requests
+type readRange struct {
+	off  int64
+	size int
+	reqs []int // indices of the range requests in the batch
+}
+
+// Read a batch of requests from r
+// Requests are sorted by offset and requests which overlap or are close to each other are coalesced into one range
+// Ranges are read concurrently. Returns the data of each request in the requests order
+func readBatch(r io.ReaderAt, reqs []readRequest) ([][]byte, error) {
+
+	order := make([]int, len(reqs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return reqs[order[i]].off < reqs[order[j]].off })
+
+	var ranges []*readRange
+	for _, i := range order {
+		req := reqs[i]
+		if len(ranges) > 0 {
+			last := ranges[len(ranges)-1]
+			if end := last.off + int64(last.size); req.off <= end+batchReadGap {
+				if reqEnd := req.off + int64(req.size); reqEnd > end {
+					last.size = int(reqEnd - last.off)
+				}
+				last.reqs = append(last.reqs, i)
+				continue
+			}
+		}
+		ranges = append(ranges, &readRange{req.off, req.size, []int{i}})
+	}
+
+	res := make([][]byte, len(reqs))
+	errs := make([]error, len(ranges))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchReadWorkers)
+
+	for ri, rng := range ranges {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ri int, rng *readRange) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			buff := make([]byte, rng.size)
+			_, err := r.ReadAt(buff, rng.off)
+			if err != nil {
+				errs[ri] = err
+				return
+			}
+
+			for _, i := range rng.reqs {
+				start := reqs[i].off - rng.off
+				res[i] = buff[start : start+int64(reqs[i].size)]
+			}
+		}(ri, rng)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
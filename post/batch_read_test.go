@@ -0,0 +1,81 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchReads(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, "post_batch.bin")
+	mf := filepath.Join(currFolder, "merkle_batch.bin")
+
+	const n, l = uint64(10), uint(7)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+	hdr := NewHeader(id, n, l, h.Id())
+
+	sw, err := NewStoreWriter(f, hdr)
+	assert.NoError(t, err)
+	for i := 0; i < 1<<n; i++ {
+		assert.NoError(t, sw.Write(rand.Uint64()&(1<<l-1), byte(l)))
+	}
+	assert.NoError(t, sw.Close())
+
+	sr, err := newStoreReader(f, l)
+	assert.NoError(t, err)
+
+	// unsorted indices with duplicates and neighbours
+	indices := make([]uint64, 200)
+	for i := range indices {
+		indices[i] = rand.Uint64() & (1<<n - 1)
+	}
+	indices = append(indices, 0, 1, 2, indices[0], 1<<n-1)
+
+	values, err := sr.ReadUint64Batch(indices)
+	assert.NoError(t, err)
+	for i, idx := range indices {
+		v, err := sr.ReadUint64(idx)
+		assert.NoError(t, err)
+		assert.Equal(t, v, values[i], "entry %d", idx)
+	}
+
+	for _, layout := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		hdr.Layout = layout
+		mw, err := NewMerkleTreeWriter(sr, mf, hdr, h)
+		assert.NoError(t, err)
+		_, err = mw.Write()
+		assert.NoError(t, err)
+
+		r, err := NewTreeStoreReader(mf, uint(n-1))
+		assert.NoError(t, err)
+
+		ids := make([]Identifier, 100)
+		for i := range ids {
+			d := uint(rand.Intn(int(n)))
+			ids[i] = nodeId(rand.Uint64()&(1<<d-1), d)
+		}
+
+		labels, err := r.ReadBatch(ids)
+		assert.NoError(t, err)
+		for i, id := range ids {
+			label, err := r.Read(id)
+			assert.NoError(t, err)
+			assert.Equal(t, label, labels[i], "layout: %s. node: %s", layout, id)
+		}
+		assert.NoError(t, r.Close())
+	}
+
+	assert.NoError(t, sr.Close())
+}
@@ -0,0 +1,75 @@
+package post
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// # of table entries or merkle labels written between checkpoints
+const checkpointInterval = 1 << 14
+
+var ErrCheckpointMismatch = errors.New("store checkpoint doesn't match table params id, n, l, hash backend or labeling")
+
+// checkpoint is the table initialization progress stored in a sidecar file next to the post store
+// It is used to resume initialization of a partially written table
+type checkpoint struct {
+	Id           []byte   `json:"id"`
+	N            uint64   `json:"n"`
+	L            uint     `json:"l"`
+	Hash         byte     `json:"hash"` // hash backend id
+	Labeling     Labeling `json:"labeling"`
+	Entries      uint64   `json:"entries"`       // # of table entries written to the store
+	MerkleLabels uint64   `json:"merkle_labels"` // # of merkle tree labels written to the merkle file
+	Commitment   []byte   `json:"commitment"`    // merkle root - set when the merkle tree is fully written
+}
+
+// Returns the checkpoint file path of a store file
+func CheckpointFileName(storeFilePath string) string {
+	return storeFilePath + ".meta"
+}
+
+// Returns true iff the checkpoint was created for table params id, n, l, hash backend and labeling
+func (c *checkpoint) matches(id []byte, n uint64, l uint, hash byte, lb Labeling) bool {
+	return bytes.Equal(c.Id, id) && c.N == n && c.L == l && c.Hash == hash && c.Labeling == lb
+}
+
+// Open the checkpoint file of a store file as a backend. The file is created empty if it doesn't exist
+func openCheckpointBackend(storeFilePath string) (Backend, error) {
+	return openFileBackend(CheckpointFileName(storeFilePath), os.O_RDWR|os.O_CREATE)
+}
+
+// Read the checkpoint stored in b. Returns nil if b is empty
+func readCheckpoint(b Backend) (*checkpoint, error) {
+	size, err := b.Size()
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	_, err = b.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	c := &checkpoint{}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	return c, nil
+}
+
+// Write the checkpoint to b
+// File backends are replaced by a new file so a crash never leaves a partially written checkpoint
+func writeCheckpoint(b Backend, c *checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return replaceBackend(b, data)
+}
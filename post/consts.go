@@ -1,9 +1,12 @@
 package post
 
-// protocol hard-coded shared constants
+import "github.com/avive/rpost/hashing"
+
+// protocol shared params are derived from the output size of Hx()
+// Every hash backend outputs hashing.Size (32) bytes - commitments are stored as hashing.Size bytes in file headers
+// and manifests and hashing.Register() rejects backends of other output sizes - so the params are constants
 
 const (
-	K  = 256 // this needs to match the output size of Hx() - when sha256() is used
-	W  = K   // merkle tree label length in bits
-	WB = 32  // W length in bytes
+	K  = hashing.Size * 8 // # of nonces in a proof and # of table entries opened per nonce. The output size of Hx() in bits
+	WB = hashing.Size     // merkle tree label length in bytes. Labels are Hx() digests
 )
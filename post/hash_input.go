@@ -0,0 +1,79 @@
+package post
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/avive/rpost/hashing"
+)
+
+// Hx() input encoding
+//
+// Every protocol hash is computed over a single unambiguous input:
+//   version   uint8 - HashEncodingVersion
+//   domain    uint8 - the protocol hash. e.g. DomainLabel
+//   fields    the hash fields in order. uint64s are 8 bytes big-endian and byte strings are prefixed by their
+//             uint32 big-endian length
+//
+// Hx() prefixes the input with the table id so every hash is bound to the id
+
+// Version of the Hx() input encoding. Files and proofs created with another version don't verify
+const HashEncodingVersion = 1
+
+// Protocol hashes domains
+const (
+	DomainLabel     byte = 1 // Hx(i, nonce) - iPoW digest of table entry i
+	DomainLeaf      byte = 2 // Hx(left, right) - merkle leaf label from its 2 store entries
+	DomainNode      byte = 3 // Hx(left, right) - merkle node label from its children labels
+	DomainIndex     byte = 4 // Hx(challenge, id, nonce, j, t) - proof table index i(j,t)
+	DomainPathProbe byte = 5 // Hx(challenge, i(j,0), data(j,0), ..., mpj) - proof pathProbe
+)
+
+var errEntryLabel = errors.New("invalid store entry label")
+
+// The encoded input of a protocol hash
+type HashInput []byte
+
+// Returns an input of the domain hash with no fields
+func NewHashInput(domain byte) HashInput {
+	return HashInput{HashEncodingVersion, domain}
+}
+
+// Appends a uint64 field
+func (in HashInput) Uint64(v uint64) HashInput {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(in, b[:]...)
+}
+
+// Appends a byte string field
+func (in HashInput) Bytes(v []byte) HashInput {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(v)))
+	return append(append(in, b[:]...), v...)
+}
+
+// Returns Hx(in)
+func (in HashInput) Hash(h hashing.HashFunc) []byte {
+	return h.Hash(in)
+}
+
+// Returns the label of a merkle leaf from its left and right store entries
+func LeafLabel(h hashing.HashFunc, left uint64, right uint64) []byte {
+	return NewHashInput(DomainLeaf).Uint64(left).Uint64(right).Hash(h)
+}
+
+// Returns the label of a merkle node from its left and right children labels
+func NodeLabel(h hashing.HashFunc, left []byte, right []byte) []byte {
+	return NewHashInput(DomainNode).Bytes(left).Bytes(right).Hash(h)
+}
+
+// Returns the store entry of a merkle proof data node label. e.g. a StoreReader.ReadBytes() result
+func EntryValue(label []byte) (uint64, error) {
+	if len(label) == 0 || len(label) > 8 {
+		return 0, errEntryLabel
+	}
+
+	var b [8]byte
+	copy(b[8-len(label):], label)
+	return binary.BigEndian.Uint64(b[:]), nil
+}
@@ -0,0 +1,47 @@
+package post
+
+import (
+	"encoding/hex"
+	"github.com/avive/rpost/hashing"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+// Test vectors of the SHA-256 backend for commitment "rpost test vector id"
+func TestHashInputVectors(t *testing.T) {
+	h := hashing.NewHashFunc([]byte("rpost test vector id"))
+
+	in := NewHashInput(DomainLabel).Uint64(1).Bytes([]byte{0x01, 0x00})
+	assert.Equal(t, "01010000000000000001000000020100", hex.EncodeToString(in))
+
+	in = NewHashInput(DomainNode).Bytes([]byte{0xaa}).Bytes(nil)
+	assert.Equal(t, "010300000001aa00000000", hex.EncodeToString(in))
+
+	// (i=1, nonce=0x0100) and (i=0x0101, nonce=0) have different labels
+	d := Labeling{}.Digest(h, 1, big.NewInt(0x0100))
+	assert.Equal(t, "8388a215c9e7ca8dbb82e456ce1991bac1fec3200351327db57e6f361594857f", hex.EncodeToString(d))
+	d = Labeling{}.Digest(h, 0x0101, big.NewInt(0))
+	assert.Equal(t, "1275dfed8409f9a84426a4b6be41f70c1405fafe9b71597dec267ac7d2a19a57", hex.EncodeToString(d))
+
+	// nonces longer than 64 bits don't collide with their 64 lsb bits
+	big65 := new(big.Int).Lsh(big.NewInt(1), 64)
+	assert.NotEqual(t, Labeling{}.Digest(h, 1, big65), Labeling{}.Digest(h, 1, big.NewInt(0)))
+
+	leaf := LeafLabel(h, 1, 2)
+	assert.Equal(t, "f6e30354f873ca0fa1464f57fed398d218bfa4af10d2d484d7f9f6793f6f28a5", hex.EncodeToString(leaf))
+
+	node := NodeLabel(h, leaf, leaf)
+	assert.Equal(t, "93cf6e0b96e2ef36c78c9b709ddf51a748047f1382977bf1357ea6e789147bc6", hex.EncodeToString(node))
+
+	// domains separate hashes of the same fields
+	assert.NotEqual(t, NewHashInput(DomainLeaf).Uint64(1).Uint64(2).Hash(h), NewHashInput(DomainLabel).Uint64(1).Uint64(2).Hash(h))
+
+	v, err := EntryValue([]byte{0x01, 0x02})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x0102), v)
+	_, err = EntryValue(nil)
+	assert.Equal(t, errEntryLabel, err)
+	_, err = EntryValue(make([]byte, 9))
+	assert.Equal(t, errEntryLabel, err)
+}
@@ -0,0 +1,239 @@
+package post
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"io"
+	"os"
+)
+
+// Post store and merkle tree files start with a fixed size header describing their content
+//
+// Header binary layout:
+//   magic      4 bytes - "RPST" for a post store file, "RPMT" for a merkle tree file
+//   version    uint8 - files of other versions were hashed with another Hx() input encoding
+//   n          uint8 - table size T = 2^n
+//   l          uint8 - # of bits stored per table entry
+//   hash id    uint8 - Hx() hash backend id
+//   id hash    32 bytes - sha256(id)
+//   commitment 32 bytes (hashing.Size) - merkle root. All 0s until the merkle tree is fully written
+//   labeling   13 bytes - table entries labeling mode uint8 and its 3 params uint32s. All 0s for Hx() labeling
+//   omitted    uint8 - # of lowest merkle tree levels which labels are not stored in a merkle tree file. 0 for a post store
+//   layout     uint8 - merkle tree file labels layout. 0 (depth-first) for a post store
+//   reserved   41 bytes - all 0s
+
+const (
+	HeaderSize    = 128
+	HeaderVersion = 2
+
+	// version of the files which entries and labels were hashed before HashEncodingVersion 1
+	legacyHeaderVersion = 1
+
+	commitmentOffset = 40
+	labelingOffset   = 72
+	omittedOffset    = labelingOffset + LabelingSize
+	layoutOffset     = omittedOffset + 1
+)
+
+var (
+	storeMagic  = [4]byte{'R', 'P', 'S', 'T'}
+	merkleMagic = [4]byte{'R', 'P', 'M', 'T'}
+)
+
+var (
+	ErrNoHeader   = errors.New("file has no rpost header. Headerless files can be migrated using MigrateStoreFile() and MigrateMerkleFile()")
+	ErrLegacyFile = errors.New("file was created with a legacy Hx() input encoding. It can be migrated using MigrateStoreFile() and MigrateMerkleFile()")
+)
+
+type Header struct {
+	Magic      [4]byte
+	Version    byte
+	N          uint64
+	L          uint
+	HashId     byte
+	IdHash     [32]byte
+	Commitment [hashing.Size]byte
+	Labeling   Labeling
+
+	// # of lowest merkle tree levels which labels are recomputed from the post store instead of being stored
+	OmittedLevels uint
+
+	// merkle tree file labels layout
+	Layout TreeLayout
+}
+
+// Create a new header for a table with commitment id, params n and l built with hash backend hashId
+func NewHeader(id []byte, n uint64, l uint, hashId byte) *Header {
+	return &Header{
+		Version: HeaderVersion,
+		N:       n,
+		L:       l,
+		HashId:  hashId,
+		IdHash:  sha256.Sum256(id),
+	}
+}
+
+// Returns a copy of the header with the provided magic
+func (h *Header) withMagic(magic [4]byte) *Header {
+	res := *h
+	res.Magic = magic
+	return &res
+}
+
+// Returns the binary encoding of the header
+func (h *Header) Bytes() []byte {
+	res := make([]byte, HeaderSize)
+	copy(res, h.Magic[:])
+	res[4] = h.Version
+	res[5] = byte(h.N)
+	res[6] = byte(h.L)
+	res[7] = h.HashId
+	copy(res[8:], h.IdHash[:])
+	copy(res[commitmentOffset:], h.Commitment[:])
+	copy(res[labelingOffset:], h.Labeling.Bytes())
+	res[omittedOffset] = byte(h.OmittedLevels)
+	res[layoutOffset] = byte(h.Layout)
+	return res
+}
+
+// Returns true iff the merkle commitment is set in the header
+func (h *Header) HasCommitment() bool {
+	return h.Commitment != [hashing.Size]byte{}
+}
+
+// Returns the merkle tree label size in bytes - the output size of the header's hash backend
+func (h *Header) LabelSize() uint64 {
+	b, err := hashing.GetBackend(h.HashId)
+	if err != nil {
+		// parsed headers always have a registered backend
+		panic(err)
+	}
+	return uint64(b.Size)
+}
+
+// Validate that the header describes a table with commitment id, params n and l built with hash backend hashId
+func (h *Header) Validate(id []byte, n uint64, l uint, hashId byte) error {
+	if h.IdHash != sha256.Sum256(id) {
+		return errors.New("file was created for a different id")
+	}
+	if h.N != n {
+		return fmt.Errorf("file was created for table size 2^%d. Expected 2^%d", h.N, n)
+	}
+	if h.L != l {
+		return fmt.Errorf("file was created with %d bits per entry. Expected %d", h.L, l)
+	}
+	if h.HashId != hashId {
+		return fmt.Errorf("file was created with hash backend %d. Expected %d", h.HashId, hashId)
+	}
+	return nil
+}
+
+// Decode a header from its binary encoding and validate it has the provided magic
+func parseHeader(data []byte, magic [4]byte) (*Header, error) {
+
+	if len(data) < HeaderSize || !bytes.Equal(data[:4], magic[:]) {
+		if len(data) >= 4 && (bytes.Equal(data[:4], storeMagic[:]) || bytes.Equal(data[:4], merkleMagic[:])) {
+			return nil, fmt.Errorf("unexpected file type %q. Expected %q", data[:4], magic[:])
+		}
+		return nil, ErrNoHeader
+	}
+
+	h := &Header{
+		Magic:   magic,
+		Version: data[4],
+		N:       uint64(data[5]),
+		L:       uint(data[6]),
+		HashId:  data[7],
+	}
+
+	if h.Version == legacyHeaderVersion {
+		return nil, ErrLegacyFile
+	}
+
+	if h.Version != HeaderVersion {
+		return nil, fmt.Errorf("unsupported header version %d", h.Version)
+	}
+
+	h.OmittedLevels = uint(data[omittedOffset])
+	if h.OmittedLevels > 0 && h.OmittedLevels >= uint(h.N) {
+		return nil, fmt.Errorf("invalid # of omitted merkle tree levels %d for table size 2^%d", h.OmittedLevels, h.N)
+	}
+
+	h.Layout = TreeLayout(data[layoutOffset])
+	if h.Layout != LayoutDepthFirst && h.Layout != LayoutLevelOrder {
+		return nil, fmt.Errorf("unknown merkle tree layout %d", h.Layout)
+	}
+
+	_, err := hashing.GetBackend(h.HashId)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(h.IdHash[:], data[8:])
+	copy(h.Commitment[:], data[commitmentOffset:])
+
+	h.Labeling, err = ParseLabeling(data[labelingOffset : labelingOffset+LabelingSize])
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Read the header of a file
+func readHeader(r io.ReaderAt, magic [4]byte) (*Header, error) {
+	data := make([]byte, HeaderSize)
+	_, err := r.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parseHeader(data, magic)
+}
+
+// Read the header of a post store file
+func ReadStoreHeader(filePath string) (*Header, error) {
+	return readFileHeader(filePath, storeMagic)
+}
+
+// Read the header of a merkle tree file
+func ReadMerkleHeader(fileName string) (*Header, error) {
+	return readFileHeader(fileName, merkleMagic)
+}
+
+func readFileHeader(fileName string, magic [4]byte) (*Header, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readHeader(f, magic)
+}
+
+// Set the merkle commitment in the header of a post store or a merkle tree file
+func WriteCommitment(fileName string, commitment []byte) error {
+	b, err := openFileBackend(fileName, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+
+	err = writeCommitment(b, commitment)
+	if err != nil {
+		b.Close()
+		return err
+	}
+
+	return b.Close()
+}
+
+// Set the merkle commitment in the header of the post store or the merkle tree store in b
+func writeCommitment(b Backend, commitment []byte) error {
+	if len(commitment) != hashing.Size {
+		return errors.New("invalid commitment length")
+	}
+
+	_, err := b.WriteAt(commitment, commitmentOffset)
+	return err
+}
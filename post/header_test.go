@@ -0,0 +1,131 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(6)
+
+	f := filepath.Join(currFolder, "post_header.bin")
+	mf := filepath.Join(currFolder, "merkle_header.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	table, err := NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	comm, err := table.Store(mf)
+	assert.NoError(t, err)
+
+	// both files describe the table and hold its commitment
+	sh, err := ReadStoreHeader(f)
+	assert.NoError(t, err)
+	assert.NoError(t, sh.Validate(id, n, l, h.Id()))
+	assert.Equal(t, comm, sh.Commitment[:])
+
+	mh, err := ReadMerkleHeader(mf)
+	assert.NoError(t, err)
+	assert.NoError(t, mh.Validate(id, n, l, h.Id()))
+	assert.Equal(t, comm, mh.Commitment[:])
+
+	assert.Error(t, sh.Validate(util.Rnd(t, 32), n, l, h.Id()))
+	assert.Error(t, sh.Validate(id, n+1, l, h.Id()))
+	assert.Error(t, sh.Validate(id, n, l+1, h.Id()))
+	assert.Error(t, sh.Validate(id, n, l, hashing.BLAKE2b256))
+
+	// readers refuse files of other params or of another type
+	_, err = newStoreReader(f, l+1)
+	assert.Error(t, err)
+	_, err = NewTreeStoreReader(mf, uint(n))
+	assert.Error(t, err)
+	_, err = newStoreReader(f, 0)
+	assert.Equal(t, errParamsL, err)
+	_, err = newMerkleTreeReader(nil, mf, 64, uint(n-1), h)
+	assert.Equal(t, errParamsL, err)
+
+	// params readers validate the file header
+	p, err := NewParams(id, n, l, h.Id())
+	assert.NoError(t, err)
+	sr, err := NewStoreReader(f, p)
+	assert.NoError(t, err)
+	mr, err := NewMerkleTreeReader(sr, mf, p)
+	assert.NoError(t, err)
+	assert.NoError(t, mr.Close())
+	assert.NoError(t, sr.Close())
+
+	q, err := NewParams(util.Rnd(t, 32), n, l, h.Id())
+	assert.NoError(t, err)
+	_, err = NewStoreReader(f, q)
+	assert.Error(t, err)
+	_, err = NewMerkleTreeReader(nil, mf, q)
+	assert.Error(t, err)
+	_, err = ReadStoreHeader(mf)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNoHeader, err)
+	_, err = ReadMerkleHeader(f)
+	assert.Error(t, err)
+
+	// files created by older versions are rejected
+	data, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(f, data[HeaderSize:], 0666)
+	assert.NoError(t, err)
+	_, err = ReadStoreHeader(f)
+	assert.Equal(t, ErrNoHeader, err)
+
+	data[4] = legacyHeaderVersion
+	err = ioutil.WriteFile(f, data, 0666)
+	assert.NoError(t, err)
+	_, err = ReadStoreHeader(f)
+	assert.Equal(t, ErrLegacyFile, err)
+	_, err = newStoreReader(f, l)
+	assert.Contains(t, err.Error(), ErrLegacyFile.Error())
+
+	// legacy files are migrated by re-deriving their entries and labels
+	mData, err := ioutil.ReadFile(mf)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(mf, mData[HeaderSize:], 0666)
+	assert.NoError(t, err)
+
+	err = MigrateMerkleFile(mf, f, p)
+	assert.Error(t, err, "expected merkle file migration to require a migrated store")
+
+	assert.NoError(t, MigrateStoreFile(f, p, Labeling{}))
+	assert.NoError(t, MigrateMerkleFile(mf, f, p))
+
+	// migrating again does nothing
+	assert.NoError(t, MigrateStoreFile(f, p, Labeling{}))
+	assert.NoError(t, MigrateMerkleFile(mf, f, p))
+	assert.Error(t, MigrateStoreFile(f, q, Labeling{}))
+
+	// table generation is deterministic so the re-derived files hold the same commitment
+	sh, err = ReadStoreHeader(f)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, sh.Commitment[:])
+	mh, err = ReadMerkleHeader(mf)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, mh.Commitment[:])
+
+	sr, err = NewStoreReader(f, p)
+	assert.NoError(t, err)
+	defer sr.Close()
+	mr, err = NewMerkleTreeReader(sr, mf, p)
+	assert.NoError(t, err)
+	defer mr.Close()
+	_, err = mr.ReadProofs(randomIndices(uint(n), 10))
+	assert.NoError(t, err)
+}
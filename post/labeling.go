@@ -0,0 +1,170 @@
+package post
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+	"math/big"
+)
+
+type LabelingMode byte
+
+// Table entries labeling modes - the function used to compute the iPoW digest of an entry nonce
+const (
+	LabelingHash     LabelingMode = 0 // Hx(i, nonce)
+	LabelingScrypt   LabelingMode = 1 // scrypt(Hx(i, nonce), i)
+	LabelingArgon2id LabelingMode = 2 // argon2id(Hx(i, nonce), i)
+)
+
+// Labeling is the labeling function of table entries and its params
+// The zero value is the default cheap Hx() labeling
+// Memory-hard modes make each entry's label memory-hard to recompute, so outsourcing
+// it to ASICs and GPUs is not much cheaper than storing it
+type Labeling struct {
+	Mode LabelingMode `json:"mode"`
+
+	// scrypt params
+	ScryptN uint32 `json:"scrypt_n,omitempty"` // CPU/memory cost. A power of 2 > 1
+	ScryptR uint32 `json:"scrypt_r,omitempty"` // block size
+	ScryptP uint32 `json:"scrypt_p,omitempty"` // parallelization
+
+	// argon2id params
+	Argon2Time    uint32 `json:"argon2_time,omitempty"`    // # of passes over the memory
+	Argon2Memory  uint32 `json:"argon2_memory,omitempty"`  // memory size in KiB
+	Argon2Threads uint8  `json:"argon2_threads,omitempty"` // # of threads
+}
+
+// Binary encoding size of a labeling: mode and 3 uint32 params
+const LabelingSize = 13
+
+// Returns a scrypt labeling with cost params n, r and p
+func NewScryptLabeling(n uint32, r uint32, p uint32) Labeling {
+	return Labeling{Mode: LabelingScrypt, ScryptN: n, ScryptR: r, ScryptP: p}
+}
+
+// Returns an argon2id labeling with time passes over memory KiB using threads threads
+func NewArgon2idLabeling(time uint32, memory uint32, threads uint8) Labeling {
+	return Labeling{Mode: LabelingArgon2id, Argon2Time: time, Argon2Memory: memory, Argon2Threads: threads}
+}
+
+// Returns an error if the labeling mode is unknown or its params are invalid
+func (lb Labeling) Validate() error {
+	switch lb.Mode {
+	case LabelingHash:
+		if lb != (Labeling{}) {
+			return errors.New("hash labeling has no params")
+		}
+	case LabelingScrypt:
+		if lb.ScryptN <= 1 || lb.ScryptN&(lb.ScryptN-1) != 0 {
+			return errors.New("scrypt N must be a power of 2 > 1")
+		}
+		if lb.ScryptR == 0 || lb.ScryptP == 0 || uint64(lb.ScryptR)*uint64(lb.ScryptP) >= 1<<30 {
+			return errors.New("scrypt r and p must be > 0 and r * p < 2^30")
+		}
+		if lb.Argon2Time != 0 || lb.Argon2Memory != 0 || lb.Argon2Threads != 0 {
+			return errors.New("scrypt labeling has no argon2id params")
+		}
+	case LabelingArgon2id:
+		if lb.Argon2Time == 0 || lb.Argon2Threads == 0 {
+			return errors.New("argon2id time and threads must be > 0")
+		}
+		if lb.Argon2Memory < 8*uint32(lb.Argon2Threads) {
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		}
+		if lb.ScryptN != 0 || lb.ScryptR != 0 || lb.ScryptP != 0 {
+			return errors.New("argon2id labeling has no scrypt params")
+		}
+	default:
+		return fmt.Errorf("unknown labeling mode %d", lb.Mode)
+	}
+	return nil
+}
+
+func (lb Labeling) String() string {
+	switch lb.Mode {
+	case LabelingHash:
+		return "hash"
+	case LabelingScrypt:
+		return fmt.Sprintf("scrypt(N=%d, r=%d, p=%d)", lb.ScryptN, lb.ScryptR, lb.ScryptP)
+	case LabelingArgon2id:
+		return fmt.Sprintf("argon2id(time=%d, memory=%dKiB, threads=%d)", lb.Argon2Time, lb.Argon2Memory, lb.Argon2Threads)
+	default:
+		return fmt.Sprintf("unknown(%d)", lb.Mode)
+	}
+}
+
+// Returns the iPoW digest of nonce for table entry i. The digest is h.Size() bytes long
+func (lb Labeling) Digest(h hashing.HashFunc, i uint64, nonce *big.Int) []byte {
+
+	// big endian fixed size buffer of i
+	var iBuf [8]byte
+	binary.BigEndian.PutUint64(iBuf[:], i)
+	// nonces may be longer than 64 bits so a nonce is a byte string field
+	d := NewHashInput(DomainLabel).Uint64(i).Bytes(nonce.Bytes()).Hash(h)
+
+	switch lb.Mode {
+	case LabelingScrypt:
+		res, err := scrypt.Key(d, iBuf[:], int(lb.ScryptN), int(lb.ScryptR), int(lb.ScryptP), h.Size())
+		if err != nil {
+			// params are validated when the labeling is set
+			panic(err)
+		}
+		return res
+	case LabelingArgon2id:
+		return argon2.IDKey(d, iBuf[:], lb.Argon2Time, lb.Argon2Memory, lb.Argon2Threads, uint32(h.Size()))
+	default:
+		return d
+	}
+}
+
+// Binary encoding of the labeling: mode and its 3 params as big-endian uint32s
+// Labelings are recorded in file headers and proofs
+func (lb Labeling) Bytes() []byte {
+	res := make([]byte, LabelingSize)
+	res[0] = byte(lb.Mode)
+
+	var params [3]uint32
+	switch lb.Mode {
+	case LabelingScrypt:
+		params = [3]uint32{lb.ScryptN, lb.ScryptR, lb.ScryptP}
+	case LabelingArgon2id:
+		params = [3]uint32{lb.Argon2Time, lb.Argon2Memory, uint32(lb.Argon2Threads)}
+	}
+
+	for i, p := range params {
+		binary.BigEndian.PutUint32(res[1+i*4:], p)
+	}
+	return res
+}
+
+// Decode and validate a labeling from its LabelingSize bytes binary encoding
+func ParseLabeling(data []byte) (Labeling, error) {
+	if len(data) != LabelingSize {
+		return Labeling{}, errors.New("invalid labeling length")
+	}
+
+	var params [3]uint32
+	for i := range params {
+		params[i] = binary.BigEndian.Uint32(data[1+i*4:])
+	}
+
+	lb := Labeling{Mode: LabelingMode(data[0])}
+	switch lb.Mode {
+	case LabelingScrypt:
+		lb.ScryptN, lb.ScryptR, lb.ScryptP = params[0], params[1], params[2]
+	case LabelingArgon2id:
+		if params[2] > 0xff {
+			return lb, errors.New("invalid argon2id threads")
+		}
+		lb.Argon2Time, lb.Argon2Memory, lb.Argon2Threads = params[0], params[1], uint8(params[2])
+	default:
+		if params != [3]uint32{} {
+			return lb, errors.New("hash labeling has no params")
+		}
+	}
+
+	return lb, lb.Validate()
+}
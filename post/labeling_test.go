@@ -0,0 +1,97 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLabeling(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(4)
+
+	f := filepath.Join(currFolder, "post_labeling.bin")
+	mf := filepath.Join(currFolder, "merkle_labeling.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+	nonce := big.NewInt(7)
+
+	labelings := []Labeling{{}, NewScryptLabeling(16, 1, 1), NewArgon2idLabeling(1, 8, 1)}
+	digests := make(map[string]bool)
+
+	for _, lb := range labelings {
+		assert.NoError(t, lb.Validate())
+
+		d := lb.Digest(h, 3, nonce)
+		assert.Len(t, d, h.Size())
+		assert.Equal(t, d, lb.Digest(h, 3, nonce), "expected a deterministic digest")
+		digests[string(d)] = true
+
+		// labeling is recorded in the store and merkle tree headers
+		table, err := NewTableWithLabeling(id, n, l, h, lb, f)
+		assert.NoError(t, err)
+		_, err = table.Store(mf)
+		assert.NoError(t, err)
+
+		sh, err := ReadStoreHeader(f)
+		assert.NoError(t, err)
+		assert.Equal(t, lb, sh.Labeling)
+		mh, err := ReadMerkleHeader(mf)
+		assert.NoError(t, err)
+		assert.Equal(t, lb, mh.Labeling)
+
+		// every stored entry is a valid iPoW for the labeling
+		sr, err := newStoreReader(f, l)
+		assert.NoError(t, err)
+		maxNonce := GetMaxNonce(l)
+		for i := uint64(0); i < 1<<n; i += 13 {
+			v, err := sr.ReadUint64(i)
+			assert.NoError(t, err)
+			assert.True(t, hasValidNonce(h, lb, i, v, l, maxNonce), "entry %d %s", i, lb)
+		}
+		assert.NoError(t, sr.Close())
+	}
+
+	assert.Len(t, digests, len(labelings), "expected labeling modes to give different digests")
+
+	// resuming with another labeling is refused
+	_, err = OpenTableWithLabeling(id, n, l, h, NewScryptLabeling(32, 1, 1), f)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+	_, err = OpenTable(id, n, l, h, f)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+
+	invalid := []Labeling{
+		{Mode: 3},
+		{ScryptN: 16},
+		NewScryptLabeling(15, 1, 1),
+		NewScryptLabeling(16, 0, 1),
+		NewArgon2idLabeling(0, 8, 1),
+		NewArgon2idLabeling(1, 8, 2),
+	}
+	for _, lb := range invalid {
+		assert.Error(t, lb.Validate(), lb.String())
+		_, err = NewTableWithLabeling(id, n, l, h, lb, f)
+		assert.Error(t, err)
+	}
+}
+
+// Returns true iff there's a permitted nonce which l lsb bits are v that is a valid iPoW for table entry i
+func hasValidNonce(h hashing.HashFunc, lb Labeling, i uint64, v uint64, l uint, maxNonce *big.Int) bool {
+	step := new(big.Int).Lsh(big.NewInt(1), l)
+	for nonce := new(big.Int).SetUint64(v); nonce.Cmp(maxNonce) <= 0; nonce.Add(nonce, step) {
+		if IsValidIPoW(h, lb, i, nonce, l) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/util"
+	"math/bits"
+	"strconv"
+)
+
+// A full binary tree store with a level-order (breadth-first) layout
+// The label of the node at depth d and position p in its level is at index 2^d - 1 + p so node offsets are computed
+// in O(1) and the labels of the upper levels are next to each other at the start of the file
+// Labels may be written in any order in which the labels of each level are written left to right, such as
+// depth-first post-order. Each level is written through its own buffer
+
+type levelStore struct {
+	backend Backend
+	n       uint   // tree height
+	k       uint   // # of lowest tree levels which labels are not stored
+	wb      uint64 // label size in bytes
+	c       uint64 // num of labels written to store
+
+	levels []*levelWriter // buffered writer of each stored level. Created on the first label written to the level
+}
+
+// Sequential writer of the labels of a tree level
+type levelWriter struct {
+	next uint64 // position of the next label in the level
+	bw   *util.Writer
+}
+
+// Create or resume a level-order store for the merkle tree of the table described by hdr
+// When c > 0 the labels of the first c nodes in depth-first post-order are kept. Otherwise a new store is started
+// b is left open on error
+func openLevelStoreWriter(b Backend, hdr *Header, c uint64) (TreeStoreWriter, error) {
+
+	res := &levelStore{
+		backend: b,
+		n:       uint(hdr.N - 1),
+		k:       hdr.OmittedLevels,
+		wb:      hdr.LabelSize(),
+		c:       c,
+	}
+	res.levels = make([]*levelWriter, res.n-res.k+1)
+
+	size, err := b.Size()
+	if err == nil {
+		if size == 0 || c == 0 {
+			// nothing to keep - start a new store
+			err = res.reset(hdr)
+		} else {
+			err = checkTreeStoreHeader(b, hdr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the store always has room for all the stored labels
+	err = b.Truncate(res.fileSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Open a level-order store for reading the labels of a tree of height n described by hdr
+func newLevelStoreReader(b Backend, hdr *Header) *levelStore {
+	return &levelStore{
+		backend: b,
+		n:       uint(hdr.N - 1),
+		k:       hdr.OmittedLevels,
+		wb:      hdr.LabelSize(),
+	}
+}
+
+// Returns the size of a file with all the stored labels
+func (d *levelStore) fileSize() int64 {
+	return int64(HeaderSize + (uint64(1)<<(d.n-d.k+1)-1)*d.wb)
+}
+
+// Truncate the file to a new header
+func (d *levelStore) reset(hdr *Header) error {
+	err := d.backend.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.backend.WriteAt(hdr.withMagic(merkleMagic).Bytes(), 0)
+	return err
+}
+
+// Returns the depth of node id and its position in its level
+// Returns ErrLabelNotStored if the node is in an omitted level
+func (d *levelStore) nodePosition(id Identifier) (uint, uint64, error) {
+	return parseNodeId(id, d.n, d.k)
+}
+
+// Returns the depth of node id of a tree of height n and its position in its level
+// Returns ErrLabelNotStored if the node is in one of the k lowest levels
+func parseNodeId(id Identifier, n uint, k uint) (uint, uint64, error) {
+
+	depth := uint(len(id))
+	if depth+k > n {
+		return 0, 0, ErrLabelNotStored
+	}
+
+	if depth == 0 {
+		return 0, 0, nil
+	}
+
+	pos, err := strconv.ParseUint(string(id), 2, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid node id %q", id)
+	}
+
+	return depth, pos, nil
+}
+
+// Returns the file offset of the label at depth and pos
+func (d *levelStore) offset(depth uint, pos uint64) int64 {
+	return int64(HeaderSize + (uint64(1)<<depth-1+pos)*d.wb)
+}
+
+func (d *levelStore) Write(id Identifier, l Label) error {
+
+	depth, pos, err := d.nodePosition(id)
+	if err != nil {
+		return err
+	}
+
+	lw := d.levels[depth]
+	if lw == nil || lw.next != pos {
+		if lw != nil {
+			err = lw.bw.Flush()
+			if err != nil {
+				return err
+			}
+		}
+
+		// buffer is no larger than the level
+		size := buffSizeBytes
+		if s := (uint64(1) << depth) * d.wb; s < uint64(size) {
+			size = int(s)
+		}
+
+		lw = &levelWriter{pos, util.NewWriterSize(&offsetWriter{d.backend, d.offset(depth, pos)}, size)}
+		d.levels[depth] = lw
+	}
+
+	_, err = lw.bw.Write(l)
+	if err != nil {
+		return err
+	}
+
+	lw.next += 1
+	d.c += 1
+	return nil
+}
+
+// Returns true iff node's label was written to the store
+func (d *levelStore) IsLabelInStore(id Identifier) (bool, error) {
+
+	depth, pos, err := d.nodePosition(id)
+	if err != nil {
+		return false, err
+	}
+
+	// labels are written in depth-first post-order. The node's subtree has m stored leaves and its index is the
+	// # of labels written up to its last leaf, minus its ancestors completed with it and itself
+	h := d.n - d.k - depth
+	m := (pos + 1) << h
+	idx := postOrderSize(m, 0) - uint64(bits.TrailingZeros64(^pos)) - 1
+
+	return idx < d.c, nil
+}
+
+// Removes all labels from the store
+func (d *levelStore) Reset() error {
+	hdr, err := readHeader(d.backend, merkleMagic)
+	if err != nil {
+		return err
+	}
+
+	d.c = 0
+	d.levels = make([]*levelWriter, len(d.levels))
+
+	err = d.reset(hdr)
+	if err != nil {
+		return err
+	}
+
+	return d.backend.Truncate(d.fileSize())
+}
+
+func (d *levelStore) Delete() error {
+	return removeBackend(d.backend)
+}
+
+// Returns the size in bytes of the labels written to the store
+func (d *levelStore) Size() uint64 {
+	return d.c * d.wb
+}
+
+func (d *levelStore) Finalize() error {
+	// flush level buffers to file
+	for _, lw := range d.levels {
+		if lw != nil {
+			err := lw.bw.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *levelStore) Close() error {
+	err := d.Finalize()
+	if err != nil {
+		d.backend.Close()
+		return err
+	}
+	return d.backend.Close()
+}
+
+// Read the labels of ids with a batch of sorted, coalesced and concurrent reads
+func (d *levelStore) ReadBatch(ids []Identifier) (Labels, error) {
+	return readLabels(d.backend, d.wb, ids, d.labelOffset)
+}
+
+// Returns the offset of the label of node id from the end of the header
+func (d *levelStore) labelOffset(id Identifier) (uint64, error) {
+	depth, pos, err := d.nodePosition(id)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(d.offset(depth, pos)) - HeaderSize, nil
+}
+
+// Read label value from the store
+// Returns the label of node id or error if it is not in the store
+func (d *levelStore) Read(id Identifier) (Label, error) {
+
+	label := make(Label, d.wb)
+
+	depth, pos, err := d.nodePosition(id)
+	if err != nil {
+		return label, err
+	}
+
+	n, err := d.backend.ReadAt(label, d.offset(depth, pos))
+	if err != nil {
+		return label, err
+	}
+
+	if uint64(n) != d.wb {
+		return label, errors.New("failed to read a label from store at provided offset")
+	}
+
+	return label, nil
+}
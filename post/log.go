@@ -0,0 +1,52 @@
+package post
+
+import (
+	"time"
+)
+
+// Logger receives the debug output of table generation and proving. e.g. a *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// # of table entries generated between progress reports
+const progressInterval = 1 << 10
+
+// Progress of a table generation or of a proof
+type Progress struct {
+	Entries  uint64        // # of table entries generated. # of nonces found when proving
+	Total    uint64        // # of table entries. # of nonces to find when proving
+	HashRate float64       // iPoW hashes per second. 0 when proving
+	ETA      time.Duration // estimated time to generate the remaining entries. 0 when proving
+	Attempts uint64        // # of proof attempts. 0 when generating a table
+}
+
+// ProgressFunc is called with the progress of a table generation or of a proof
+type ProgressFunc func(p Progress)
+
+// Measures the progress of a table generation
+type progressMeter struct {
+	start  time.Time
+	first  uint64 // # of entries in the store when generation started
+	total  uint64
+	hashes uint64 // # of iPoW hashes computed
+}
+
+func newProgressMeter(first uint64, total uint64) *progressMeter {
+	return &progressMeter{start: time.Now(), first: first, total: total}
+}
+
+// Returns the progress after generating entries table entries
+func (m *progressMeter) progress(entries uint64) Progress {
+	res := Progress{Entries: entries, Total: m.total}
+
+	elapsed := time.Since(m.start).Seconds()
+	if elapsed == 0 || entries == m.first {
+		return res
+	}
+
+	res.HashRate = float64(m.hashes) / elapsed
+	rate := float64(entries-m.first) / elapsed
+	res.ETA = time.Duration(float64(m.total-entries) / rate * float64(time.Second))
+	return res
+}
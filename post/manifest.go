@@ -0,0 +1,244 @@
+package post
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ManifestVersion is the version of the shard manifest format
+const ManifestVersion = 1
+
+// Manifest describes the shards of a post store and of its merkle tree
+// The 2^n table entries are split into 2^ShardBits shards of contiguous index ranges. The merkle tree is split into
+// the subtrees of the shards entries and the tree levels above them
+// Shard paths are relative to the manifest directory unless they are absolute
+type Manifest struct {
+	Version    byte    `json:"version"`
+	N          uint64  `json:"n"`
+	L          uint    `json:"l"`
+	ShardBits  uint    `json:"shard_bits"` // log2 of the # of shards
+	Shards     []Shard `json:"shards"`
+	MerkleTop  string  `json:"merkle_top"` // merkle tree levels above the shards subtrees. Empty for a single shard
+	Commitment []byte  `json:"commitment"` // hashing.Size bytes merkle root - set when the merkle tree is fully written
+
+	dir string // manifest directory
+}
+
+// A shard of a post store
+type Shard struct {
+	First   uint64 `json:"first"`   // index of the first table entry in the shard
+	Entries uint64 `json:"entries"` // # of table entries in the shard
+	Store   string `json:"store"`   // post store file of the shard entries
+	Merkle  string `json:"merkle"`  // merkle tree file of the subtree of the shard entries
+}
+
+// Create a manifest of a table of 2^n l bits entries split into 2^shardBits shards
+// Shard files are spread round-robin across dirs. Relative dirs are relative to the manifest directory
+func NewManifest(n uint64, l uint, shardBits uint, dirs []string) (*Manifest, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("no shard directories")
+	}
+
+	m := &Manifest{Version: ManifestVersion, N: n, L: l, ShardBits: shardBits}
+
+	if shardBits > 0 {
+		m.MerkleTop = filepath.Join(dirs[0], "merkle-top.bin")
+	}
+
+	entries := uint64(1) << (n - uint64(shardBits))
+	for i := uint64(0); i < uint64(1)<<shardBits; i++ {
+		dir := dirs[i%uint64(len(dirs))]
+		m.Shards = append(m.Shards, Shard{
+			First:   i * entries,
+			Entries: entries,
+			Store:   filepath.Join(dir, fmt.Sprintf("post-%d.bin", i)),
+			Merkle:  filepath.Join(dir, fmt.Sprintf("merkle-%d.bin", i)),
+		})
+	}
+
+	return m, m.Validate()
+}
+
+// Validate that the shards are equal contiguous index ranges covering the table
+// Each shard must have at least 8 entries so shards start on a byte boundary
+func (m *Manifest) Validate() error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	if m.N < 9 || m.N > 63 || m.L < 1 || m.L > 63 {
+		return errors.New("invalid manifest table params")
+	}
+
+	if uint64(m.ShardBits)+3 > m.N {
+		return fmt.Errorf("a table of size 2^%d can be split into at most 2^%d shards", m.N, m.N-3)
+	}
+
+	if uint64(len(m.Shards)) != uint64(1)<<m.ShardBits {
+		return fmt.Errorf("manifest has %d shards. Expected %d", len(m.Shards), uint64(1)<<m.ShardBits)
+	}
+
+	if m.Commitment != nil && len(m.Commitment) != hashing.Size {
+		return errors.New("invalid manifest commitment length")
+	}
+
+	if (m.ShardBits > 0) != (m.MerkleTop != "") {
+		return errors.New("merkle top levels file must be set iff there is more than one shard")
+	}
+
+	entries := m.shardEntries()
+	for i, s := range m.Shards {
+		if s.First != uint64(i)*entries || s.Entries != entries {
+			return fmt.Errorf("shard %d must hold entries [%d, %d)", i, uint64(i)*entries, uint64(i+1)*entries)
+		}
+		if s.Store == "" || s.Merkle == "" {
+			return fmt.Errorf("shard %d has no files", i)
+		}
+	}
+
+	return nil
+}
+
+// Returns true iff m and o split the same table into the same shard files
+func (m *Manifest) sameShards(o *Manifest) bool {
+	if m.N != o.N || m.L != o.L || m.ShardBits != o.ShardBits || m.MerkleTop != o.MerkleTop || len(m.Shards) != len(o.Shards) {
+		return false
+	}
+	for i, s := range m.Shards {
+		if s != o.Shards[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the # of contiguous table entries in the shard store files from the first table entry
+func (m *Manifest) storedEntries() (uint64, error) {
+	var res uint64
+	for _, s := range m.Shards {
+		fi, err := os.Stat(m.path(s.Store))
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var c uint64
+		if fi.Size() > HeaderSize {
+			c = uint64(fi.Size()-HeaderSize) * 8 / uint64(m.L)
+		}
+		if c < s.Entries {
+			return res + c, nil
+		}
+		res += s.Entries
+	}
+	return res, nil
+}
+
+// Returns the # of entries in each shard
+func (m *Manifest) shardEntries() uint64 {
+	return uint64(1) << (m.N - uint64(m.ShardBits))
+}
+
+// Returns the path of a shard file
+func (m *Manifest) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+// Returns the header of the store and merkle tree files of the shards of the table described by hdr
+// A shard is a table of 2^(n-ShardBits) entries
+func (m *Manifest) shardHeader(hdr *Header) *Header {
+	res := *hdr
+	res.N = m.N - uint64(m.ShardBits)
+	return &res
+}
+
+// Returns the header of the merkle tree file of the levels above the shards subtrees
+// These levels are a tree of height ShardBits-1 which labels are all stored
+func (m *Manifest) topHeader(hdr *Header) *Header {
+	res := *hdr
+	res.N = uint64(m.ShardBits)
+	res.OmittedLevels = 0
+	return &res
+}
+
+// Validate that the shard store files were created for the table with commitment id built with hash backend hashId
+func (m *Manifest) ValidateShards(id []byte, hashId byte) error {
+	for _, s := range m.Shards {
+		h, err := ReadStoreHeader(m.path(s.Store))
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Store, err)
+		}
+
+		err = h.Validate(id, m.N-uint64(m.ShardBits), m.L, hashId)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Store, err)
+		}
+	}
+	return nil
+}
+
+// Returns the labeling of the table entries read from the shard store headers
+func (m *Manifest) Labeling() (Labeling, error) {
+	var lb Labeling
+	for i, s := range m.Shards {
+		h, err := ReadStoreHeader(m.path(s.Store))
+		if err != nil {
+			return lb, fmt.Errorf("%s: %v", s.Store, err)
+		}
+
+		if i > 0 && h.Labeling != lb {
+			return lb, fmt.Errorf("%s: shard labeling %s doesn't match the labeling %s of shard 0", s.Store,
+				h.Labeling, lb)
+		}
+		lb = h.Labeling
+	}
+	return lb, nil
+}
+
+// Read and validate a manifest file
+func ReadManifest(fileName string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	err = m.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	m.dir = filepath.Dir(fileName)
+	return m, nil
+}
+
+// Write a manifest file
+func WriteManifest(fileName string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(fileName, data, 0666)
+	if err != nil {
+		return err
+	}
+
+	m.dir = filepath.Dir(fileName)
+	return nil
+}
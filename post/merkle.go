@@ -1,8 +1,12 @@
 package post
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/avive/rpost/bstring"
 	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
 	"math/big"
 )
 
@@ -12,6 +16,7 @@ const (
 
 type MerkleTreeWriter interface {
 	Write() ([]byte, error)
+	WriteContext(ctx context.Context) ([]byte, error) // write until done or ctx is done. Returns ctx.Err() when interrupted
 }
 
 type MerkleTreeReader interface {
@@ -35,59 +40,207 @@ type merkleTree struct {
 	psr      StoreReader      // Data store data reader
 	h        hashing.HashFunc // Hx()
 	f        bstring.BinaryStringFactory
-	w        TreeStoreWriter // merkle tree store writer
-	r        TreeStoreReader // merkle tree store reader
+	r        TreeStoreReader   // merkle tree store reader
+	acc      MerkleAccumulator // merkle tree builder of the writer
 }
 
-// n - merkle tree size = 2^n
-func NewMerkleTreeReader(psr StoreReader, fileName string, l uint, n uint, h hashing.HashFunc) (MerkleTreeReader, error) {
+// Returns a reader of the merkle tree of the table with params p which store is read from psr
+// Returns an error if the merkle tree file was created for other params
+func NewMerkleTreeReader(psr StoreReader, fileName string, p *Params, opts ...ReaderOption) (MerkleTreeReader, error) {
 
-	r, err := NewTreeStoreReader(fileName, n)
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := ReadMerkleHeader(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	err = hdr.Validate(p.Id, p.N, p.L, p.HashId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMerkleTreeReader(psr, fileName, p.L, p.Height(), h, opts...)
+}
+
+// Open the post store and merkle tree files of a table which params are read from the store header. e.g. to inspect
+// the files of an unknown table. Returns an error if the merkle tree file is of another table
+// h is used to recompute the labels of the tree levels omitted from the file. It may be nil when no levels are omitted
+func OpenTableReaders(storeFile string, merkleFile string, h hashing.HashFunc,
+	opts ...ReaderOption) (StoreReader, MerkleTreeReader, error) {
+
+	sh, err := ReadStoreHeader(storeFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", storeFile, err)
+	}
+
+	mh, err := ReadMerkleHeader(merkleFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", merkleFile, err)
+	}
+
+	if mh.IdHash != sh.IdHash || mh.N != sh.N || mh.L != sh.L || mh.HashId != sh.HashId {
+		return nil, nil, fmt.Errorf("%s: merkle tree file is of another table than %s", merkleFile, storeFile)
+	}
+
+	if h != nil && h.Id() != sh.HashId {
+		return nil, nil, fmt.Errorf("%s: file was created with hash backend %d. Expected %d", storeFile, sh.HashId, h.Id())
+	}
+
+	sr, err := newStoreReader(storeFile, sh.L, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mr, err := newMerkleTreeReader(sr, merkleFile, sh.L, uint(sh.N-1), h, opts...)
+	if err != nil {
+		sr.Close()
+		return nil, nil, err
+	}
+
+	return sr, mr, nil
+}
+
+// n - merkle tree height. The tree has 2^n leaves
+// h is used to recompute the labels of the tree levels omitted from the file. It may be nil when no levels are omitted
+func newMerkleTreeReader(psr StoreReader, fileName string, l uint, n uint, h hashing.HashFunc,
+	opts ...ReaderOption) (MerkleTreeReader, error) {
+
+	// the table params of a tree of height n are n+1 and l
+	if n < 8 || n > 62 {
+		return nil, errParamsN
+	}
+	if l < 1 || l > 63 {
+		return nil, errParamsL
+	}
+
+	r, err := NewTreeStoreReader(fileName, n, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	res := &merkleTree{
-		fileName, l, n, psr, h, bstring.NewSMBinaryStringFactory(), nil, r,
+		fileName: fileName, l: l, n: n, psr: psr, h: h, f: bstring.NewSMBinaryStringFactory(), r: r,
 	}
 
 	return res, nil
 }
 
-// n - store length. T = 2^n
-func NewMerkleTreeWriter(psr StoreReader, fileName string, l uint, n uint,
+// Returns a reader of the merkle tree of the table with params p which store is read from psr and labels from the
+// tree store r
+func NewMerkleTreeReaderWithStore(psr StoreReader, r TreeStoreReader, p *Params) (MerkleTreeReader, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return &merkleTree{l: p.L, n: p.Height(), psr: psr, h: h, f: bstring.NewSMBinaryStringFactory(), r: r}, nil
+}
+
+// hdr - header of the table which merkle tree is written. Store length T = 2^hdr.N
+func NewMerkleTreeWriter(psr StoreReader, fileName string, hdr *Header,
 	h hashing.HashFunc) (MerkleTreeWriter, error) {
+	return OpenMerkleTreeWriter(psr, fileName, hdr, h, 0, nil)
+}
+
+// Returns a writer of the merkle tree of psr to the tree store w. Writing can't be resumed
+// hdr - header of the table which merkle tree is written. Store length T = 2^hdr.N
+func NewMerkleTreeWriterWithStore(psr StoreReader, w TreeStoreWriter, hdr *Header, h hashing.HashFunc) MerkleTreeWriter {
+	return &merkleTree{
+		l: hdr.L, n: uint(hdr.N), psr: psr, h: h, f: bstring.NewSMBinaryStringFactory(), acc: NewMerkleAccumulator(w, hdr, h),
+	}
+}
+
+// Resume writing a merkle tree from the first labels labels of a partially written merkle tree file
+// Subtrees which labels are already in the file are not recomputed
+// progress is called with the # of labels flushed to the file every checkpointInterval written labels. It may be nil
+// hdr - header of the table which merkle tree is written. Store length T = 2^hdr.N
+func OpenMerkleTreeWriter(psr StoreReader, fileName string, hdr *Header, h hashing.HashFunc, labels uint64,
+	progress func(labels uint64) error) (MerkleTreeWriter, error) {
 
-	w, err := NewTreeStoreWriter(fileName, n-1)
+	acc, err := OpenMerkleAccumulator(fileName, hdr, h, labels, progress)
 	if err != nil {
 		return nil, err
 	}
 
 	res := &merkleTree{
-		fileName, l, n, psr, h, bstring.NewSMBinaryStringFactory(), w, nil,
+		fileName: fileName, l: hdr.L, n: uint(hdr.N), psr: psr, h: h, f: bstring.NewSMBinaryStringFactory(), acc: acc,
 	}
 
 	return res, nil
 }
 
 // for each store table idx in indices, return the Merkle path from the node at that index to the merkle tree root
+// The store entries and labels of all the paths are read in one batch
 func (mt *merkleTree) ReadProofs(indices []*big.Int) (MerkleProofs, error) {
 
 	mps := make(MerkleProofs, len(indices))
 
-	for idx, data := range indices {
+	// data node siblings and the ids of the merkle nodes of all the paths
+	sibs := make([]uint64, len(indices))
+	ids := make([]Identifier, 0, len(indices)*int(mt.n))
 
-		bn, err := mt.f.NewBinaryStringFromInt(data.Uint64(), mt.n+1)
-		if err != nil {
-			return nil, err
-		}
+	for i, data := range indices {
 
-		path, err := mt.ReadProof(Identifier(bn.GetStringValue()))
-		if err != nil {
-			return nil, err
+		idx := data.Uint64()
+		sibs[i] = idx ^ 1
+
+		path := make(MerkleProof, mt.n+1)
+		path[0].Id = nodeId(sibs[i], mt.n+1)
+
+		// siblings of the merkle leaf of the entry and of its ancestors, from the leaf up
+		leaf := idx >> 1
+		for d := mt.n; d > 0; d-- {
+			id := nodeId((leaf>>(mt.n-d))^1, d)
+			path[mt.n-d+1].Id = id
+			ids = append(ids, id)
 		}
 
-		mps[idx] = path
+		mps[i] = path
+	}
+
+	values, err := mt.psr.ReadUint64Batch(sibs)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := mt.r.ReadBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range mps {
+		path[0].Label = util.EncodeToBytes(values[i])
+
+		for j := range path[1:] {
+			l := labels[i*int(mt.n)+j]
+			if l == nil {
+				// node is in an omitted level
+				id, err := mt.f.NewBinaryString(string(path[j+1].Id))
+				if err != nil {
+					return nil, err
+				}
+
+				l, err = mt.computeLabel(id)
+				if err != nil {
+					return nil, err
+				}
+			}
+			path[j+1].Label = l
+		}
 	}
 
 	return mps, nil
@@ -101,7 +254,8 @@ func (mt *merkleTree) ReadProofs(indices []*big.Int) (MerkleProofs, error) {
 func (mt *merkleTree) ReadProof(id Identifier) (MerkleProof, error) {
 
 	// fmt.Printf("Create merkle proof for node id: %s\n", id)
-	res := make(MerkleProof, len(id)+1)
+	// data node sibling and the n merkle nodes siblings on the path to the root
+	res := make(MerkleProof, mt.n+1)
 
 	currNodeId, err := mt.f.NewBinaryString(string(id))
 	if err != nil {
@@ -139,6 +293,9 @@ func (mt *merkleTree) ReadProof(id Identifier) (MerkleProof, error) {
 		// fmt.Printf("Reading merkle node for node id: %s\n", idx)
 
 		l, err := mt.r.Read(idx)
+		if err == ErrLabelNotStored {
+			l, err = mt.computeLabel(nodeId)
+		}
 
 		if err != nil {
 			return nil, err
@@ -150,72 +307,105 @@ func (mt *merkleTree) ReadProof(id Identifier) (MerkleProof, error) {
 	return res, nil
 }
 
-// Write the Merkle tree of the provided store to the store
-// Returns the Merkle root commitment for the data
-func (mt *merkleTree) Write() ([]byte, error) {
+// Recompute the label of a node in an omitted tree level from the store entries of its subtree
+func (mt *merkleTree) computeLabel(id bstring.BinaryString) (Label, error) {
 
-	// Merkle tree height equals to log of data size minus 1
-	// h := mt.n - 1
-	// Number of table entries
-	// t := uint64(math.Pow(2, float64(mt.n)))
+	if mt.h == nil {
+		return nil, errors.New("recomputing omitted merkle tree labels requires the merkle tree hash function")
+	}
+
+	// node height in the merkle tree. Its subtree has 2^(height+1) store entries
+	height := mt.n - id.GetDigitsCount()
 
-	comm, err := mt.write(rootId)
+	it, err := newEntryIterator(mt.psr, id.GetValue()<<(height+1))
 	if err != nil {
 		return nil, err
 	}
 
-	err = mt.w.Close()
+	label, err := subtreeLabel(mt.h, it, height)
 	if err != nil {
 		return nil, err
 	}
 
-	return comm, nil
+	return label, it.close()
 }
 
-// visit a node identified by nodeId and returns its value
-func (mt *merkleTree) write(nodeId string) ([]byte, error) {
+// Returns the label of a subtree of the provided height which store entries are read from it
+func subtreeLabel(h hashing.HashFunc, it entryIterator, height uint) ([]byte, error) {
 
-	var leftNodeValue, rightNodeValue []byte
-
-	if uint(len(nodeId)) == mt.n-1 {
-		// Node is a merkle tree leaf
-		// e.g. for n = 2 (post table size 4), node "0" and "1" of length 1 should be Merkle leafs
-		// node is a Merkle leaf node - compute its value based on the data in the store
-		// e.g. hash of left and right post table entries
-		bs, err := mt.f.NewBinaryString(nodeId)
+	if height == 0 {
+		left, err := it.next()
 		if err != nil {
 			return nil, err
 		}
 
-		// data index for left and right nodes
-		idx := bs.GetValue() * 2
-		leftNodeValue, err = mt.psr.ReadBytes(idx)
+		right, err := it.next()
 		if err != nil {
 			return nil, err
 		}
 
-		rightNodeValue, err = mt.psr.ReadBytes(idx + 1)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// Node is an internal Merkle tree node
-		// Recursively compute its value based on its children and store it
-		var err error
-		leftNodeValue, err = mt.write(nodeId + "0")
-		if err != nil {
+		return LeafLabel(h, left, right), nil
+	}
+
+	left, err := subtreeLabel(h, it, height-1)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := subtreeLabel(h, it, height-1)
+	if err != nil {
+		return nil, err
+	}
+
+	return NodeLabel(h, left, right), nil
+}
+
+// Write the Merkle tree of the provided store to the store
+// Returns the Merkle root commitment for the data
+func (mt *merkleTree) Write() ([]byte, error) {
+	return mt.WriteContext(context.Background())
+}
+
+// Write the Merkle tree until done or ctx is done
+// A failed write of the tree store is returned and the tree store is closed
+// When ctx is done the labels written so far are flushed and reported to the progress func, the merkle tree file is
+// closed and ctx.Err() is returned
+func (mt *merkleTree) WriteContext(ctx context.Context) ([]byte, error) {
+
+	// feed the store entries which labels are not in the merkle tree file to the accumulator
+	it, err := newEntryIterator(mt.psr, mt.acc.Entries())
+	if err != nil {
+		return nil, err
+	}
+
+	for i := mt.acc.Entries(); i < uint64(1)<<mt.n; i++ {
+		if err := ctx.Err(); err != nil {
+			it.close()
+			cerr := mt.acc.Close()
+			if cerr != nil {
+				return nil, cerr
+			}
 			return nil, err
 		}
 
-		rightNodeValue, err = mt.write(nodeId + "1")
+		v, err := it.next()
+		if err == nil {
+			err = mt.acc.Add(v)
+		}
 		if err != nil {
+			// e.g. the tree store is out of space
+			it.close()
+			mt.acc.Close()
 			return nil, err
 		}
 	}
 
-	digest := mt.h.Hash(leftNodeValue, rightNodeValue)
-	mt.w.Write(Identifier(nodeId), digest)
-	return digest, nil
+	err = it.close()
+	if err != nil {
+		return nil, err
+	}
+
+	return mt.acc.Commit()
 }
 
 // Close the reader if it is open
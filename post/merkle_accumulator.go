@@ -0,0 +1,310 @@
+package post
+
+import (
+	"errors"
+	"github.com/avive/rpost/hashing"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MerkleAccumulator incrementally builds the merkle tree of store entries which are added in table order
+// Labels are written to the merkle tree file in depth-first post-order as soon as they can be computed so
+// the commitment is ready right after the last entry is added
+// Labels of the merkle tree levels omitted by the header are computed but not written
+type MerkleAccumulator interface {
+	Add(v uint64) error      // add the next store entry
+	Entries() uint64         // # of store entries added. Including entries covered by the labels the writing resumed from
+	Commit() ([]byte, error) // returns the merkle root after all table entries were added and closes the merkle tree file
+	Close() error            // flush the written labels and close the merkle tree file w/o committing
+}
+
+// A label of a subtree root which parent's label wasn't computed yet
+type stackNode struct {
+	label  []byte
+	height uint // subtree height. 0 for merkle leaves
+}
+
+type merkleAccumulator struct {
+	b        Backend // merkle tree store backend the commitment is written to. nil when writing to any other tree store
+	height   uint    // merkle tree height
+	k        uint    // # of lowest tree levels which labels are not written
+	h        hashing.HashFunc
+	w        TreeStoreWriter
+	progress func(labels uint64) error // called with the # of labels flushed to the store every checkpointInterval labels
+	c        uint64                    // # of labels in the store
+
+	// labels of the subtrees which parents weren't computed yet - at most one per tree level
+	stack   []stackNode
+	leaves  uint64 // # of merkle leaves added
+	left    uint64 // left entry of the next merkle leaf
+	hasLeft bool
+}
+
+// Returns the # of labels in the merkle tree file after adding m merkle leaves
+// k - # of omitted tree levels
+func postOrderSize(m uint64, k uint) uint64 {
+	m >>= k
+	return 2*m - uint64(bits.OnesCount64(m))
+}
+
+// Returns the id of the node at pos in its tree level at depth. e.g. its depth bits binary representation
+func nodeId(pos uint64, depth uint) Identifier {
+	if depth == 0 {
+		return rootId
+	}
+	s := strconv.FormatUint(pos, 2)
+	return Identifier(strings.Repeat("0", int(depth)-len(s)) + s)
+}
+
+// Returns an accumulator writing the merkle tree of the table described by hdr to the empty tree store w
+// Writing can't be resumed and the commitment is only returned by Commit()
+func NewMerkleAccumulator(w TreeStoreWriter, hdr *Header, h hashing.HashFunc) MerkleAccumulator {
+	return &merkleAccumulator{
+		height: uint(hdr.N - 1),
+		k:      hdr.OmittedLevels,
+		h:      h,
+		w:      w,
+	}
+}
+
+// Open an accumulator writing the merkle tree of the table described by hdr to fileName
+// Writing resumes after the first labels labels of a partially written merkle tree file. Entries() returns the
+// index of the first store entry to add
+// progress is called with the # of labels flushed to the file every checkpointInterval written labels. It may be nil
+func OpenMerkleAccumulator(fileName string, hdr *Header, h hashing.HashFunc, labels uint64,
+	progress func(labels uint64) error) (MerkleAccumulator, error) {
+
+	b, err := openFileBackend(fileName, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := OpenMerkleAccumulatorWithBackend(b, hdr, h, labels, progress)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return acc, nil
+}
+
+// Open an accumulator writing the merkle tree of the table described by hdr to the tree store in b
+// The commitment is written to the header of the store by Commit(). b is left open on error
+func OpenMerkleAccumulatorWithBackend(b Backend, hdr *Header, h hashing.HashFunc, labels uint64,
+	progress func(labels uint64) error) (MerkleAccumulator, error) {
+
+	w, err := OpenTreeStoreWriterWithBackend(nopCloseBackend{b}, hdr, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	acc := &merkleAccumulator{
+		b:        b,
+		height:   uint(hdr.N - 1),
+		k:        hdr.OmittedLevels,
+		h:        h,
+		w:        w,
+		progress: progress,
+		c:        labels,
+	}
+
+	if labels > 0 {
+		err = acc.resume()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return acc, nil
+}
+
+func (a *merkleAccumulator) Entries() uint64 {
+	res := a.leaves * 2
+	if a.hasLeft {
+		res += 1
+	}
+	return res
+}
+
+func (a *merkleAccumulator) Add(v uint64) error {
+
+	if a.leaves == uint64(1)<<a.height {
+		return errors.New("all table entries were added")
+	}
+
+	if !a.hasLeft {
+		a.left = v
+		a.hasLeft = true
+		return nil
+	}
+
+	// merkle leaf label is the hash of its left and right store entries
+	label := LeafLabel(a.h, a.left, v)
+	a.hasLeft = false
+	a.leaves++
+
+	err := a.writeLabel(label, 0)
+	if err != nil {
+		return err
+	}
+
+	a.stack = append(a.stack, stackNode{label, 0})
+	return a.collapse()
+}
+
+func (a *merkleAccumulator) Commit() ([]byte, error) {
+
+	if a.leaves != uint64(1)<<a.height || len(a.stack) != 1 {
+		return nil, errors.New("not all table entries were added")
+	}
+
+	err := a.w.Close()
+	if err != nil {
+		a.closeBackend()
+		return nil, err
+	}
+
+	comm := a.stack[0].label
+
+	if a.b != nil {
+		err = writeCommitment(a.b, comm)
+		if err != nil {
+			a.closeBackend()
+			return nil, err
+		}
+	}
+
+	return comm, a.closeBackend()
+}
+
+// Flush the labels written so far, report them to progress and close the store
+// Writing can be resumed from the reported labels
+func (a *merkleAccumulator) Close() error {
+	err := a.w.Finalize()
+	if err != nil {
+		a.w.Close()
+		a.closeBackend()
+		return err
+	}
+
+	if a.progress != nil {
+		err := a.progress(a.c)
+		if err != nil {
+			a.w.Close()
+			a.closeBackend()
+			return err
+		}
+	}
+
+	err = a.w.Close()
+	if err != nil {
+		a.closeBackend()
+		return err
+	}
+	return a.closeBackend()
+}
+
+// Close the backend of the tree store. The tree store writer doesn't close it so the commitment can be written
+func (a *merkleAccumulator) closeBackend() error {
+	if a.b == nil {
+		return nil
+	}
+	return a.b.Close()
+}
+
+// Compute the parents of sibling subtrees on top of the stack
+func (a *merkleAccumulator) collapse() error {
+	for len(a.stack) > 1 && a.stack[len(a.stack)-1].height == a.stack[len(a.stack)-2].height {
+		left, right := a.stack[len(a.stack)-2], a.stack[len(a.stack)-1]
+		a.stack = a.stack[:len(a.stack)-2]
+
+		label := NodeLabel(a.h, left.label, right.label)
+		err := a.writeLabel(label, left.height+1)
+		if err != nil {
+			return err
+		}
+
+		a.stack = append(a.stack, stackNode{label, left.height + 1})
+	}
+	return nil
+}
+
+// Append the label of a node of height h to the store and report progress every checkpointInterval labels
+// Labels of omitted levels are not written
+func (a *merkleAccumulator) writeLabel(label []byte, h uint) error {
+
+	if h < a.k {
+		return nil
+	}
+
+	// the node is the last completed node of its level
+	err := a.w.Write(nodeId(a.leaves>>h-1, a.height-h), label)
+	if err != nil {
+		return err
+	}
+
+	a.c += 1
+	if a.progress != nil && a.c%checkpointInterval == 0 {
+		// flush written labels before reporting them
+		err = a.w.Finalize()
+		if err != nil {
+			return err
+		}
+		return a.progress(a.c)
+	}
+
+	return nil
+}
+
+// Restore the stack from the labels in the store
+// The labels in the store are a post-order prefix of the stored levels. e.g. a sequence of complete subtrees of
+// non-increasing heights
+func (a *merkleAccumulator) resume() error {
+
+	r, err := NewTreeStoreReaderWithBackend(nopCloseBackend{a.b}, a.height)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	rem := a.c
+
+	// height of the tree of the stored levels
+	top := a.height - a.k
+
+	if rem >= uint64(1)<<(top+1)-1 {
+		// all labels are in the store
+		label, err := r.Read(rootId)
+		if err != nil {
+			return err
+		}
+		a.stack = []stackNode{{label, a.height}}
+		a.leaves = uint64(1) << a.height
+		return nil
+	}
+
+	// descend from the root into the incomplete subtree containing the last label in the store
+	for h := top; rem > 0; h-- {
+
+		// # of stored labels of each child subtree and the child height in the merkle tree
+		s := uint64(1)<<h - 1
+		ch := h - 1 + a.k
+
+		// children which subtrees are in the store
+		for c := 0; c < 2 && rem >= s; c++ {
+
+			label, err := r.Read(nodeId(a.leaves>>ch, a.height-ch))
+			if err != nil {
+				return err
+			}
+
+			a.stack = append(a.stack, stackNode{label, ch})
+			a.leaves += uint64(1) << ch
+			rem -= s
+		}
+	}
+
+	// the store may end right before the label of the parent of the last 2 subtrees
+	return a.collapse()
+}
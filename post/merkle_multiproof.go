@@ -0,0 +1,194 @@
+package post
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/bstring"
+	"github.com/avive/rpost/hashing"
+	"math/big"
+	"sort"
+)
+
+// MultiProof is a batched merkle opening of a set of store entries
+// Each sibling label needed to compute the root from the opened entries is included exactly once
+// Nodes are ordered level by level, starting with the store entries level (depth n) up to
+// the root children level (depth 1), and by position within each level
+type MultiProof struct {
+	Indices []uint64 // sorted unique indices of the opened store entries
+	Nodes   []Node   // sibling nodes needed to compute the root from the opened entries
+}
+
+// a node position and its label at some tree level
+type posLabel struct {
+	pos   uint64
+	label Label
+}
+
+// Create a MultiProof from the merkle paths of the store entries at indices
+// mps[i] is the merkle path of indices[i] as returned by MerkleTreeReader.ReadProofs()
+// n - store size T = 2^n
+func NewMultiProof(indices []*big.Int, mps MerkleProofs, n uint) (*MultiProof, error) {
+
+	if len(indices) != len(mps) {
+		return nil, errors.New("expected a merkle path for each index")
+	}
+
+	// known sibling labels by depth and position
+	labels := make([]map[uint64]Label, n+1)
+	for d := range labels {
+		labels[d] = make(map[uint64]Label)
+	}
+
+	level := make([]posLabel, 0, len(indices))
+
+	for i, idx := range indices {
+		path := mps[i]
+		if uint(len(path)) != n {
+			return nil, fmt.Errorf("expected %d nodes on path. Got %d", n, len(path))
+		}
+
+		pos := idx.Uint64()
+		for j, node := range path {
+			labels[n-uint(j)][(pos>>uint(j))^1] = node.Label
+		}
+
+		level = append(level, posLabel{pos: pos})
+	}
+
+	level = sortLevel(level)
+
+	res := &MultiProof{Indices: make([]uint64, len(level))}
+	for i, e := range level {
+		res.Indices[i] = e.pos
+	}
+
+	f := bstring.NewSMBinaryStringFactory()
+
+	// walk the tree bottom up and collect any sibling which can't be computed from the opened entries
+	for d := n; d > 0; d-- {
+		var next []posLabel
+		for i := 0; i < len(level); i++ {
+			pos := level[i].pos
+			if pos%2 == 0 && i+1 < len(level) && level[i+1].pos == pos+1 {
+				i++
+			} else {
+				id, err := f.NewBinaryStringFromInt(pos^1, d)
+				if err != nil {
+					return nil, err
+				}
+				res.Nodes = append(res.Nodes, Node{Identifier(id.GetStringValue()), labels[d][pos^1]})
+			}
+			next = append(next, posLabel{pos: pos >> 1})
+		}
+		level = next
+	}
+
+	return res, nil
+}
+
+// Verify the multi proof - compute the merkle root from the opened store entries and the proof nodes
+// values[i] is the store entry at mp.Indices[i], root is the expected merkle root and n - store size T = 2^n
+func (mp *MultiProof) Verify(h hashing.HashFunc, values [][]byte, root []byte, n uint) error {
+
+	if len(values) != len(mp.Indices) || len(values) == 0 {
+		return errors.New("expected a value for each opened index")
+	}
+
+	level := make([]posLabel, len(values))
+	for i, v := range values {
+		if i > 0 && mp.Indices[i] <= mp.Indices[i-1] {
+			return errors.New("indices must be sorted and unique")
+		}
+		if n < 64 && mp.Indices[i]>>n != 0 {
+			return fmt.Errorf("index %d out of range", mp.Indices[i])
+		}
+		level[i] = posLabel{mp.Indices[i], v}
+	}
+
+	f := bstring.NewSMBinaryStringFactory()
+	nodeIdx := 0
+
+	for d := n; d > 0; d-- {
+		var next []posLabel
+		for i := 0; i < len(level); i++ {
+
+			pos := level[i].pos
+			var left, right Label
+
+			if pos%2 == 0 && i+1 < len(level) && level[i+1].pos == pos+1 {
+				// both children are known
+				left, right = level[i].label, level[i+1].label
+				i++
+			} else {
+				if nodeIdx == len(mp.Nodes) {
+					return errors.New("missing proof nodes")
+				}
+
+				node := mp.Nodes[nodeIdx]
+				nodeIdx++
+
+				id, err := f.NewBinaryStringFromInt(pos^1, d)
+				if err != nil {
+					return err
+				}
+
+				if string(node.Id) != id.GetStringValue() {
+					return fmt.Errorf("unexpected node id %s", node.Id)
+				}
+
+				if pos%2 == 0 {
+					left, right = level[i].label, node.Label
+				} else {
+					left, right = node.Label, level[i].label
+				}
+			}
+
+			label, err := parentLabel(h, left, right, d == n)
+			if err != nil {
+				return err
+			}
+			next = append(next, posLabel{pos >> 1, label})
+		}
+		level = next
+	}
+
+	if nodeIdx != len(mp.Nodes) {
+		return errors.New("unexpected extra proof nodes")
+	}
+
+	if !bytes.Equal(level[0].label, root) {
+		return errors.New("computed root doesn't match commitment")
+	}
+
+	return nil
+}
+
+// Sort level entries by position and remove duplicates
+func sortLevel(level []posLabel) []posLabel {
+	sort.Slice(level, func(i, j int) bool { return level[i].pos < level[j].pos })
+	res := level[:0]
+	for i, e := range level {
+		if i == 0 || e.pos != level[i-1].pos {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Returns the label of the parent of left and right. The children of a merkle leaf are store entries
+func parentLabel(h hashing.HashFunc, left Label, right Label, leaf bool) (Label, error) {
+	if !leaf {
+		return NodeLabel(h, left, right), nil
+	}
+
+	l, err := EntryValue(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := EntryValue(right)
+	if err != nil {
+		return nil, err
+	}
+	return LeafLabel(h, l, r), nil
+}
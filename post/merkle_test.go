@@ -5,13 +5,16 @@ import (
 	"github.com/avive/rpost/hashing"
 	"github.com/avive/rpost/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
 )
 
 func TestMerkleWriter(t *testing.T) {
-	testMerkleStore(t, 4, 20, "post1.bin", "merkle1.bin")
+	testMerkleStore(t, 9, 8, "post1.bin", "merkle1.bin")
 }
 
 // n - Table size T = 2^n
@@ -46,7 +49,7 @@ func testMerkleStore(t *testing.T, n uint64, l uint, postFileName string, merkle
 	sr := NewMemoryStoreReader(res)
 
 	// test merkle tree writer from memory post data
-	mw, err := NewMerkleTreeWriter(sr, mf, l, uint(n), h)
+	mw, err := NewMerkleTreeWriter(sr, mf, NewHeader(id, n, l, h.Id()), h)
 	assert.NoError(t, err)
 
 	comm, err := mw.Write()
@@ -54,9 +57,9 @@ func testMerkleStore(t *testing.T, n uint64, l uint, postFileName string, merkle
 	fmt.Printf("Merkle commitment: 0x%x \n", comm)
 
 	// test merkle tree generation from post store
-	sr, err = NewStoreReader(f, l)
+	sr, err = newStoreReader(f, l)
 	assert.NoError(t, err)
-	mw, err = NewMerkleTreeWriter(sr, mf, l, uint(n), h)
+	mw, err = NewMerkleTreeWriter(sr, mf, NewHeader(id, n, l, h.Id()), h)
 	assert.NoError(t, err)
 	comm1, err := mw.Write()
 	assert.NoError(t, err)
@@ -66,12 +69,12 @@ func testMerkleStore(t *testing.T, n uint64, l uint, postFileName string, merkle
 
 	// test reading proofs from the merkle tree
 
-	mr, err := NewMerkleTreeReader(sr, mf, l, uint(n-1), h)
+	mr, err := newMerkleTreeReader(sr, mf, l, uint(n-1), h)
 	assert.NoError(t, err)
 
-	path, err := mr.ReadProof("101")
+	path, err := mr.ReadProof("10100101")
 	assert.NoError(t, err)
-	assert.Equal(t, len(path), 4, "expected 4 nodes on the path from 101 to root")
+	assert.Equal(t, len(path), 9, "expected 9 nodes on the path from 10100101 to root")
 	for _, n := range path {
 		fmt.Printf("Id: %s. Label: 0x%x\n", n.Id, n.Label)
 	}
@@ -81,3 +84,295 @@ func testMerkleStore(t *testing.T, n uint64, l uint, postFileName string, merkle
 	assert.NoError(t, err)
 
 }
+
+func TestMultiProof(t *testing.T) {
+
+	const n = uint(10)
+
+	mr, comm, sr, h := newRandomMerkleTree(t, n, 20, "post2.bin", "merkle2.bin")
+	defer mr.Close()
+
+	// random indices including a duplicate and a pair of siblings
+	indices := randomIndices(n, 20)
+	indices = append(indices, new(big.Int).Set(indices[0]), big.NewInt(6), big.NewInt(7))
+
+	mps, err := mr.ReadProofs(indices)
+	assert.NoError(t, err)
+
+	mp, err := NewMultiProof(indices, mps, n)
+	assert.NoError(t, err)
+
+	values := make([][]byte, len(mp.Indices))
+	for i, idx := range mp.Indices {
+		values[i], err = sr.ReadBytes(idx)
+		assert.NoError(t, err)
+	}
+
+	err = mp.Verify(h, values, comm, n)
+	assert.NoError(t, err)
+
+	// a tampered value should not verify
+	values[1] = append([]byte{}, values[1]...)
+	values[1][0] ^= 1
+	err = mp.Verify(h, values, comm, n)
+	assert.Error(t, err)
+	values[1][0] ^= 1
+
+	// a tampered node label should not verify
+	mp.Nodes[3].Label = util.Rnd(t, uint(WB))
+	err = mp.Verify(h, values, comm, n)
+	assert.Error(t, err)
+}
+
+func BenchmarkMultiProofSize(b *testing.B) {
+
+	const n = uint(16)
+
+	mr, _, _, _ := newRandomMerkleTree(b, n, 20, "post3.bin", "merkle3.bin")
+	defer mr.Close()
+
+	var mpsSize, mpSize int
+
+	for i := 0; i < b.N; i++ {
+		indices := randomIndices(n, K)
+
+		mps, err := mr.ReadProofs(indices)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		mp, err := NewMultiProof(indices, mps, n)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		mpsSize, mpSize = 0, 0
+		for _, path := range mps {
+			for _, node := range path {
+				mpsSize += len(node.Label)
+			}
+		}
+		for _, node := range mp.Nodes {
+			mpSize += len(node.Label)
+		}
+	}
+
+	b.ReportMetric(float64(mpsSize), "merkle-proofs-bytes")
+	b.ReportMetric(float64(mpSize), "multi-proof-bytes")
+}
+
+// Write a merkle tree for a random in-memory store of 2^n entries of l bits
+// Returns a reader of the tree, its commitment, the store and the tree hash func
+func newRandomMerkleTree(t assert.TestingT, n uint, l uint, postFileName string,
+	merkleFileName string) (MerkleTreeReader, []byte, StoreReader, hashing.HashFunc) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	mf := filepath.Join(currFolder, merkleFileName)
+
+	id := util.Rnd1(32)
+	h := hashing.NewHashFunc(id)
+
+	data := make([]uint64, 1<<n)
+	for i := range data {
+		data[i] = rand.Uint64() & (1<<l - 1)
+	}
+	sr := NewMemoryStoreReader(data)
+
+	mw, err := NewMerkleTreeWriter(sr, mf, NewHeader(id, uint64(n), l, h.Id()), h)
+	assert.NoError(t, err)
+	comm, err := mw.Write()
+	assert.NoError(t, err)
+
+	mr, err := newMerkleTreeReader(sr, mf, l, n-1, h)
+	assert.NoError(t, err)
+
+	return mr, comm, sr, h
+}
+
+// Returns c random store indices for a store of size 2^n
+func randomIndices(n uint, c int) []*big.Int {
+	res := make([]*big.Int, c)
+	for i := range res {
+		res[i] = new(big.Int).SetUint64(rand.Uint64() & (1<<n - 1))
+	}
+	return res
+}
+
+func TestMerkleBuilder(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, "post_builder.bin")
+	mf := filepath.Join(currFolder, "merkle_builder.bin")
+
+	const l = uint(6)
+
+	for _, n := range []uint64{2, 5, 10} {
+
+		id := util.Rnd(t, 32)
+		h := hashing.NewHashFunc(id)
+		hdr := NewHeader(id, n, l, h.Id())
+
+		data := make([]uint64, 1<<n)
+		sw, err := NewStoreWriter(f, hdr)
+		assert.NoError(t, err)
+		for i := range data {
+			data[i] = rand.Uint64() & (1<<l - 1)
+			assert.NoError(t, sw.Write(data[i], byte(l)))
+		}
+		assert.NoError(t, sw.Close())
+
+		// expected labels in depth-first post-order
+		var expected []byte
+		root := postOrderLabels(h, data, &expected)
+
+		for _, fromFile := range []bool{false, true} {
+			var sr StoreReader = NewMemoryStoreReader(data)
+			if fromFile {
+				sr, err = newStoreReader(f, l)
+				assert.NoError(t, err)
+			}
+
+			mw, err := NewMerkleTreeWriter(sr, mf, hdr, h)
+			assert.NoError(t, err)
+			comm, err := mw.Write()
+			assert.NoError(t, err)
+			assert.Equal(t, root, comm)
+
+			labels, err := ioutil.ReadFile(mf)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, labels[HeaderSize:])
+
+			if fromFile {
+				assert.NoError(t, sr.Close())
+			}
+		}
+
+		// resume writing from any # of labels in the store
+		sr := NewMemoryStoreReader(data)
+		wb := uint64(WB)
+		total := uint64(len(expected)) / wb
+		for c := uint64(0); c <= total; c += 1 + total/40 {
+			err = os.Truncate(mf, int64(HeaderSize+c*wb))
+			assert.NoError(t, err)
+
+			mw, err := OpenMerkleTreeWriter(sr, mf, hdr, h, c, nil)
+			assert.NoError(t, err)
+			comm, err := mw.Write()
+			assert.NoError(t, err)
+			assert.Equal(t, root, comm, "resumed from %d labels", c)
+
+			labels, err := ioutil.ReadFile(mf)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, labels[HeaderSize:], "resumed from %d labels", c)
+		}
+	}
+}
+
+// Reference recursive merkle tree of data. Appends the tree labels in depth-first post-order to labels
+// and returns the root label
+func postOrderLabels(h hashing.HashFunc, data []uint64, labels *[]byte) []byte {
+	var res []byte
+	if len(data) == 2 {
+		res = LeafLabel(h, data[0], data[1])
+	} else {
+		left := postOrderLabels(h, data[:len(data)/2], labels)
+		right := postOrderLabels(h, data[len(data)/2:], labels)
+		res = NodeLabel(h, left, right)
+	}
+	*labels = append(*labels, res...)
+	return res
+}
+
+func TestOmittedLevels(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	mf := filepath.Join(currFolder, "merkle_all_levels.bin")
+	mf1 := filepath.Join(currFolder, "merkle_omitted_levels.bin")
+
+	const n, l = uint64(10), uint(6)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+	wb := uint64(WB)
+
+	data := make([]uint64, 1<<n)
+	for i := range data {
+		data[i] = rand.Uint64() & (1<<l - 1)
+	}
+	sr := NewMemoryStoreReader(data)
+
+	mw, err := NewMerkleTreeWriter(sr, mf, NewHeader(id, n, l, h.Id()), h)
+	assert.NoError(t, err)
+	comm, err := mw.Write()
+	assert.NoError(t, err)
+
+	mr, err := newMerkleTreeReader(sr, mf, l, uint(n-1), h)
+	assert.NoError(t, err)
+	indices := randomIndices(uint(n), 20)
+	proofs, err := mr.ReadProofs(indices)
+	assert.NoError(t, err)
+	assert.NoError(t, mr.Close())
+
+	for _, k := range []uint{1, 4, uint(n - 1)} {
+
+		hdr := NewHeader(id, n, l, h.Id())
+		hdr.OmittedLevels = k
+
+		mw, err := NewMerkleTreeWriter(sr, mf1, hdr, h)
+		assert.NoError(t, err)
+		comm1, err := mw.Write()
+		assert.NoError(t, err)
+		assert.Equal(t, comm, comm1, "omitted levels: %d", k)
+
+		// only the labels of the top n-k levels are stored
+		fi, err := os.Stat(mf1)
+		assert.NoError(t, err)
+		stored := uint64(1)<<(uint(n)-k) - 1
+		assert.Equal(t, int64(HeaderSize+stored*wb), fi.Size(), "omitted levels: %d", k)
+
+		// same proofs with recomputed lower levels
+		mr, err := newMerkleTreeReader(sr, mf1, l, uint(n-1), h)
+		assert.NoError(t, err)
+		proofs1, err := mr.ReadProofs(indices)
+		assert.NoError(t, err)
+		assert.Equal(t, proofs, proofs1, "omitted levels: %d", k)
+		assert.NoError(t, mr.Close())
+
+		labels, err := ioutil.ReadFile(mf1)
+		assert.NoError(t, err)
+
+		// resume writing from any # of stored labels
+		for c := uint64(0); c <= stored; c += 1 + stored/10 {
+			err = os.Truncate(mf1, int64(HeaderSize+c*wb))
+			assert.NoError(t, err)
+
+			mw, err := OpenMerkleTreeWriter(sr, mf1, hdr, h, c, nil)
+			assert.NoError(t, err)
+			comm1, err := mw.Write()
+			assert.NoError(t, err)
+			assert.Equal(t, comm, comm1, "omitted levels: %d. resumed from %d labels", k, c)
+			labels1, err := ioutil.ReadFile(mf1)
+			assert.NoError(t, err)
+			assert.Equal(t, labels, labels1, "omitted levels: %d. resumed from %d labels", k, c)
+		}
+	}
+
+	// omitted labels can't be recomputed without Hx()
+	mr, err = newMerkleTreeReader(sr, mf1, l, uint(n-1), nil)
+	assert.NoError(t, err)
+	_, err = mr.ReadProofs(indices)
+	assert.Error(t, err)
+	assert.NoError(t, mr.Close())
+}
@@ -0,0 +1,86 @@
+package post
+
+import (
+	"fmt"
+	"os"
+)
+
+// Files created by older versions have no header or were hashed with a legacy Hx() input encoding
+// Their store entries are nonces which passed the iPoW check under the legacy encoding and their merkle labels were
+// hashed with it, so neither verifies under the current encoding. Migrating a table re-derives both
+
+// Re-derive the entries of a post store file created by an older version under the current Hx() input encoding
+// The legacy entries can't be reused so this costs as much as initializing the table. Does nothing if the file is
+// current. Migrate the merkle tree file with MigrateMerkleFile() after the store file
+func MigrateStoreFile(filePath string, p *Params, lb Labeling) error {
+
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := ReadStoreHeader(filePath)
+	if err == nil {
+		return hdr.Validate(p.Id, p.N, p.L, p.HashId)
+	}
+	if err != ErrNoHeader && err != ErrLegacyFile {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	t, err := NewTableWithParams(p, lb, filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Generate(false)
+	return err
+}
+
+// Re-derive the labels of a merkle tree file created by an older version from its migrated post store file
+// The commitment is written to the headers of both files. Does nothing if the merkle tree file is current
+func MigrateMerkleFile(fileName string, storeFile string, p *Params) error {
+
+	_, err := ReadMerkleHeader(fileName)
+	if err == nil {
+		return nil
+	}
+	if err != ErrNoHeader && err != ErrLegacyFile {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	hdr, err := ReadStoreHeader(storeFile)
+	if err != nil {
+		return fmt.Errorf("%s: %v. Migrate the store file first", storeFile, err)
+	}
+
+	sr, err := NewStoreReader(storeFile, p)
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return err
+	}
+
+	// the legacy file is replaced only once all labels are written
+	tmpFileName := fileName + ".tmp"
+	w, err := NewMerkleTreeWriter(sr, tmpFileName, hdr, h)
+	if err != nil {
+		return err
+	}
+
+	comm, err := w.Write()
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+
+	err = os.Rename(tmpFileName, fileName)
+	if err != nil {
+		return err
+	}
+
+	return WriteCommitment(storeFile, comm)
+}
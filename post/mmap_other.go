@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package post
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File) ([]byte, error) {
+	return nil, errors.New("memory-mapped readers are not supported on this platform")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
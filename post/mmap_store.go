@@ -0,0 +1,163 @@
+package post
+
+import (
+	"errors"
+	"github.com/Workiva/go-datastructures/bitarray"
+	"github.com/avive/rpost/util"
+	"os"
+)
+
+// ReaderOption configures a store or tree store reader
+type ReaderOption func(o *readerOptions)
+
+type readerOptions struct {
+	mmap bool
+}
+
+// Read the file through a read-only memory mapping instead of ReadAt calls
+// Reads don't allocate buffers and tree store labels are slices of the mapping which are only valid until the
+// reader is closed
+func WithMmap() ReaderOption {
+	return func(o *readerOptions) {
+		o.mmap = true
+	}
+}
+
+func newReaderOptions(opts []ReaderOption) *readerOptions {
+	res := &readerOptions{}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+var errEntryNotFound = errors.New("data for idx not found")
+
+// A memory-mapped post store reader
+type mmapStore struct {
+	filePath string
+	file     *os.File
+	data     []byte // the mapped file entries - without the header
+	mapping  []byte
+	n        uint // number of bits stored per entry
+}
+
+func newMmapStore(filePath string, f *os.File, n uint) (StoreReader, error) {
+	mapping, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmapStore{filePath, f, mapping[HeaderSize:], mapping, n}, nil
+}
+
+func (s *mmapStore) ReadUint64(idx uint64) (uint64, error) {
+	offsetBits := idx * uint64(s.n)
+	if (offsetBits+uint64(s.n)+7)/8 > uint64(len(s.data)) {
+		return 0, errEntryNotFound
+	}
+	return decodeEntry(s.data[offsetBits/8:], uint(offsetBits%8), s.n), nil
+}
+
+func (s *mmapStore) ReadUint64Batch(indices []uint64) ([]uint64, error) {
+	res := make([]uint64, len(indices))
+	for i, idx := range indices {
+		v, err := s.ReadUint64(idx)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func (s *mmapStore) ReadBytes(idx uint64) ([]byte, error) {
+	v, err := s.ReadUint64(idx)
+	if err != nil {
+		return nil, err
+	}
+	return util.EncodeToBytes(v), nil
+}
+
+func (s *mmapStore) Read(idx uint64) (bitarray.BitArray, error) {
+	v, err := s.ReadUint64(idx)
+	if err != nil {
+		return bitarray.NewBitArray(uint64(s.n), false), err
+	}
+	return entryBitArray(v, s.n)
+}
+
+func (s *mmapStore) Close() error {
+	err := munmap(s.mapping)
+	if err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *mmapStore) FileName() string {
+	return s.filePath
+}
+
+// A memory-mapped tree store reader of any layout
+type mmapTreeStore struct {
+	r       TreeStoreReader // the layout reader. Owns the file
+	mapping []byte
+	wb      uint64
+
+	// offset of a node label from the end of the header or ErrLabelNotStored for omitted labels
+	offset func(id Identifier) (uint64, error)
+}
+
+func newMmapTreeStore(r TreeStoreReader, f *os.File, wb uint64, offset func(id Identifier) (uint64, error)) (TreeStoreReader, error) {
+	mapping, err := mmapFile(f)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return &mmapTreeStore{r, mapping, wb, offset}, nil
+}
+
+// Returns the label of node id. The label is a slice of the mapping
+func (d *mmapTreeStore) Read(id Identifier) (Label, error) {
+	off, err := d.offset(id)
+	if err != nil {
+		return nil, err
+	}
+
+	start := HeaderSize + off
+	end := start + d.wb
+	if end > uint64(len(d.mapping)) {
+		return nil, errors.New("failed to read a label from store at provided offset")
+	}
+
+	return Label(d.mapping[start:end:end]), nil
+}
+
+func (d *mmapTreeStore) ReadBatch(ids []Identifier) (Labels, error) {
+	res := make(Labels, len(ids))
+	for i, id := range ids {
+		l, err := d.Read(id)
+		if err == ErrLabelNotStored {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[i] = l
+	}
+	return res, nil
+}
+
+func (d *mmapTreeStore) Size() uint64 {
+	return d.r.Size()
+}
+
+func (d *mmapTreeStore) Close() error {
+	err := munmap(d.mapping)
+	if err != nil {
+		return err
+	}
+	return d.r.Close()
+}
@@ -0,0 +1,115 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapReaders(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, "post_mmap.bin")
+	mf := filepath.Join(currFolder, "merkle_mmap.bin")
+
+	const n, l = uint64(10), uint(5)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+	hdr := NewHeader(id, n, l, h.Id())
+
+	sw, err := NewStoreWriter(f, hdr)
+	assert.NoError(t, err)
+	for i := 0; i < 1<<n; i++ {
+		assert.NoError(t, sw.Write(rand.Uint64()&(1<<l-1), byte(l)))
+	}
+	assert.NoError(t, sw.Close())
+
+	sr, err := newStoreReader(f, l)
+	assert.NoError(t, err)
+	msr, err := newStoreReader(f, l, WithMmap())
+	assert.NoError(t, err)
+
+	indices := make([]uint64, 1<<n)
+	for i := range indices {
+		indices[i] = uint64(i)
+
+		v, err := sr.Read(uint64(i))
+		assert.NoError(t, err)
+		v1, err := msr.Read(uint64(i))
+		assert.NoError(t, err)
+		assert.True(t, v.Equals(v1), "entry %d", i)
+	}
+
+	values, err := sr.ReadUint64Batch(indices)
+	assert.NoError(t, err)
+	values1, err := msr.ReadUint64Batch(indices)
+	assert.NoError(t, err)
+	assert.Equal(t, values, values1)
+
+	_, err = msr.ReadUint64(1 << n)
+	assert.Error(t, err)
+
+	proofIndices := make([]*big.Int, 20)
+	for i := range proofIndices {
+		proofIndices[i] = new(big.Int).SetUint64(rand.Uint64() & (1<<n - 1))
+	}
+
+	for _, layout := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		for _, k := range []uint{0, 3} {
+			hdr.Layout = layout
+			hdr.OmittedLevels = k
+			mw, err := NewMerkleTreeWriter(sr, mf, hdr, h)
+			assert.NoError(t, err)
+			_, err = mw.Write()
+			assert.NoError(t, err)
+
+			var ids []Identifier
+			postOrderIds(uint(n-1), k, rootId, &ids)
+
+			r, err := NewTreeStoreReader(mf, uint(n-1))
+			assert.NoError(t, err)
+			mr, err := NewTreeStoreReader(mf, uint(n-1), WithMmap())
+			assert.NoError(t, err)
+
+			labels, err := r.ReadBatch(ids)
+			assert.NoError(t, err)
+			labels1, err := mr.ReadBatch(ids)
+			assert.NoError(t, err)
+			assert.Equal(t, labels, labels1, "layout: %s. omitted levels: %d", layout, k)
+
+			label, err := mr.Read(rootId)
+			assert.NoError(t, err)
+			assert.Equal(t, labels[len(labels)-1], label)
+
+			assert.NoError(t, r.Close())
+			assert.NoError(t, mr.Close())
+
+			// same proofs with memory-mapped files
+			tr, err := newMerkleTreeReader(sr, mf, l, uint(n-1), h)
+			assert.NoError(t, err)
+			proofs, err := tr.ReadProofs(proofIndices)
+			assert.NoError(t, err)
+			assert.NoError(t, tr.Close())
+
+			tr, err = newMerkleTreeReader(msr, mf, l, uint(n-1), h, WithMmap())
+			assert.NoError(t, err)
+			proofs1, err := tr.ReadProofs(proofIndices)
+			assert.NoError(t, err)
+			assert.Equal(t, proofs, proofs1, "layout: %s. omitted levels: %d", layout, k)
+			assert.NoError(t, tr.Close())
+		}
+	}
+
+	assert.NoError(t, sr.Close())
+	assert.NoError(t, msr.Close())
+}
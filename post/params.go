@@ -0,0 +1,96 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"math"
+)
+
+// Params are the protocol params shared by a table, its prover and verifier
+// Use NewParams() to derive the difficulties from id, n and l
+type Params struct {
+	Id     []byte // initial commitment
+	N      uint64 // table size T = 2^N. 9 <= N <= 63
+	L      uint   // # of nonce bits stored per table entry. 1 <= L <= 63
+	HashId byte   // id of the hash backend of Hx()
+
+	K                   int  // # of nonces in a proof and # of table entries opened per nonce
+	IPoWDifficulty      uint // # of leading 0 bits of a table entry iPoW hash
+	PathProbeDifficulty uint // # of leading 0 bits of a proof pathProbe
+}
+
+var (
+	errParamsN = errors.New("n must be in [9, 63]")
+	errParamsL = errors.New("l must be in [1, 63]")
+)
+
+// Returns the params of a table of 2^n l bits entries for commitment id using the hash backend hashId
+func NewParams(id []byte, n uint64, l uint, hashId byte) (*Params, error) {
+	p := &Params{Id: id, N: n, L: l, HashId: hashId, K: K, IPoWDifficulty: l}
+	if n >= 9 && n <= 63 {
+		p.PathProbeDifficulty = pathProbeDifficulty(p.K, n)
+	}
+
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Returns the difficulty of pathProbe < phi where phi = K / T
+func pathProbeDifficulty(k int, n uint64) uint {
+	return util.GetDifficulty(float64(k) / math.Pow(2, float64(n)))
+}
+
+// Returns an error if p isn't a valid and consistent set of params
+func (p *Params) Validate() error {
+
+	if len(p.Id) == 0 {
+		return errors.New("missing id")
+	}
+
+	if p.N < 9 || p.N > 63 {
+		return errParamsN
+	}
+
+	if p.L < 1 || p.L > 63 {
+		return errParamsL
+	}
+
+	_, err := hashing.GetBackend(p.HashId)
+	if err != nil {
+		return err
+	}
+
+	if p.K != K {
+		return fmt.Errorf("k must be %d", K)
+	}
+
+	if p.IPoWDifficulty != p.L {
+		return fmt.Errorf("iPoW difficulty must be %d", p.L)
+	}
+
+	if d := pathProbeDifficulty(p.K, p.N); p.PathProbeDifficulty != d {
+		return fmt.Errorf("pathProbe difficulty must be %d", d)
+	}
+
+	return nil
+}
+
+// Returns Hx() for the params commitment and hash backend
+func (p *Params) HashFunc() (hashing.HashFunc, error) {
+	return hashing.NewHashFuncWithBackend(p.Id, p.HashId)
+}
+
+// Returns the merkle tree height. The tree has 2^(N-1) leaves
+func (p *Params) Height() uint {
+	return uint(p.N - 1)
+}
+
+// Returns the header of the table store and merkle tree files
+func (p *Params) Header() *Header {
+	return NewHeader(p.Id, p.N, p.L, p.HashId)
+}
@@ -0,0 +1,60 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestParams(t *testing.T) {
+	id := util.Rnd(t, 32)
+
+	p, err := NewParams(id, 10, 6, hashing.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, 256, p.K)
+	assert.Equal(t, uint(6), p.IPoWDifficulty)
+	assert.Equal(t, uint(2), p.PathProbeDifficulty) // phi = 256 / 2^10
+	assert.Equal(t, uint(9), p.Height())
+
+	h, err := p.HashFunc()
+	assert.NoError(t, err)
+	assert.Equal(t, hashing.SHA256, h.Id())
+
+	_, err = NewParams(id, 8, 6, hashing.SHA256)
+	assert.Error(t, err)
+	_, err = NewParams(id, 64, 6, hashing.SHA256)
+	assert.Error(t, err)
+	_, err = NewParams(id, 10, 0, hashing.SHA256)
+	assert.Error(t, err)
+	_, err = NewParams(id, 10, 64, hashing.SHA256)
+	assert.Error(t, err)
+	_, err = NewParams(nil, 10, 6, hashing.SHA256)
+	assert.Error(t, err)
+	_, err = NewParams(id, 10, 6, 0)
+	assert.Error(t, err)
+
+	// the max nonce of the largest l doesn't overflow
+	maxNonce, ok := new(big.Int).SetString("2361183241434822606848", 10) // 256 * 2^63
+	assert.True(t, ok)
+	assert.Equal(t, maxNonce, GetMaxNonce(63))
+	assert.Equal(t, big.NewInt(256*64), GetMaxNonce(6))
+
+	// derived params must match
+	q := *p
+	q.K = 128
+	assert.Error(t, q.Validate())
+	q = *p
+	q.IPoWDifficulty = 7
+	assert.Error(t, q.Validate())
+	q = *p
+	q.PathProbeDifficulty = 3
+	assert.Error(t, q.Validate())
+
+	// constructors reject params out of range
+	_, err = NewTable(id, 4, 6, hashing.NewHashFunc(id), "")
+	assert.Error(t, err)
+	_, err = NewMemoryTable(id, 10, 0, hashing.NewHashFunc(id), Labeling{}, NewMemoryStore(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Workiva/go-datastructures/bitarray"
+	"github.com/avive/rpost/util"
+)
+
+// A post store split into the shards of a manifest
+// Entries are written to the shards in table order and reads are addressed to the shard holding the entry
+
+type shardedStoreWriter struct {
+	fileName string // manifest file
+	m        *Manifest
+	shards   []StoreWriter
+	cur      int    // shard written to
+	bits     uint64 // # of bits written to the current shard
+}
+
+type shardedStoreReader struct {
+	fileName string // manifest file
+	m        *Manifest
+	shards   []StoreReader
+}
+
+// Write the manifest m to fileName and create the shard store files of the table described by hdr
+// Any existing shard file is truncated
+func NewShardedStoreWriter(fileName string, m *Manifest, hdr *Header) (StoreWriter, error) {
+
+	if m.N != hdr.N || m.L != hdr.L {
+		return nil, errors.New("manifest was created for different table params")
+	}
+
+	err := WriteManifest(fileName, m)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &shardedStoreWriter{fileName: fileName, m: m}
+
+	for _, s := range m.Shards {
+		w, err := NewStoreWriter(m.path(s.Store), m.shardHeader(hdr))
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.shards = append(res.shards, w)
+	}
+
+	return res, nil
+}
+
+// Open the shard store files of the manifest m written to fileName for appending entries after its first entries
+// entries. Shards are truncated to hold exactly entries entries. entries*l must be a multiple of 8
+func OpenShardedStoreWriter(fileName string, m *Manifest, hdr *Header, entries uint64) (StoreWriter, error) {
+
+	if m.N != hdr.N || m.L != hdr.L {
+		return nil, errors.New("manifest was created for different table params")
+	}
+
+	if entries > uint64(1)<<m.N {
+		return nil, errors.New("manifest table has fewer entries")
+	}
+
+	res := &shardedStoreWriter{fileName: fileName, m: m}
+
+	for _, s := range m.Shards {
+
+		// # of entries kept in the shard
+		var c uint64
+		if entries > s.First {
+			c = entries - s.First
+			if c > s.Entries {
+				c = s.Entries
+			}
+		}
+
+		w, err := OpenStoreWriter(m.path(s.Store), m.shardHeader(hdr), c)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.shards = append(res.shards, w)
+	}
+
+	// the last shard is the current shard when all entries were written
+	res.cur = int(entries / m.shardEntries())
+	if res.cur == len(res.shards) {
+		res.cur--
+	}
+	res.bits = (entries - uint64(res.cur)*m.shardEntries()) * uint64(m.L)
+
+	return res, nil
+}
+
+// Append the n lsb bits of r. Bits past the end of a shard are written to the next shard
+func (s *shardedStoreWriter) Write(r uint64, n byte) error {
+	size := s.m.shardEntries() * uint64(s.m.L)
+
+	for n > 0 {
+		if s.bits == size {
+			if s.cur == len(s.shards)-1 {
+				return errors.New("all table entries were written")
+			}
+			s.cur++
+			s.bits = 0
+		}
+
+		c := n
+		if rem := size - s.bits; rem < uint64(c) {
+			c = byte(rem)
+		}
+
+		err := s.shards[s.cur].Write(r>>(n-c)&(uint64(1)<<c-1), c)
+		if err != nil {
+			return err
+		}
+
+		s.bits += uint64(c)
+		n -= c
+		r &= uint64(1)<<n - 1
+	}
+
+	return nil
+}
+
+func (s *shardedStoreWriter) WriteBool(b bool) error {
+	var r uint64
+	if b {
+		r = 1
+	}
+	return s.Write(r, 1)
+}
+
+func (s *shardedStoreWriter) Close() error {
+	var res error
+	for _, w := range s.shards {
+		err := w.Close()
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// Returns the manifest file name
+func (s *shardedStoreWriter) FileName() string {
+	return s.fileName
+}
+
+// Returns a reader of the entries written to the shards. Closing the writer closes the reader shards
+func (s *shardedStoreWriter) reader() (StoreReader, error) {
+	res := &shardedStoreReader{fileName: s.fileName, m: s.m}
+	for _, w := range s.shards {
+		r, ok := w.(StoreReader)
+		if !ok {
+			return nil, errors.New("shard store can't be read")
+		}
+		res.shards = append(res.shards, r)
+	}
+	return res, nil
+}
+
+// Open the shards of the manifest fileName of the table with params p for reading
+// Returns an error if the manifest or its shards were created for another table
+func NewShardedStoreReader(fileName string, p *Params, opts ...ReaderOption) (StoreReader, error) {
+
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := ReadManifest(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.N != p.N || m.L != p.L {
+		return nil, fmt.Errorf("%s: manifest was created for n=%d l=%d", fileName, m.N, m.L)
+	}
+
+	err = m.ValidateShards(p.Id, p.HashId)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &shardedStoreReader{fileName: fileName, m: m}
+
+	for _, s := range m.Shards {
+		r, err := newStoreReader(m.path(s.Store), p.L, opts...)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.shards = append(res.shards, r)
+	}
+
+	return res, nil
+}
+
+// Returns the shard holding entry idx and the entry index in the shard
+func (s *shardedStoreReader) shard(idx uint64) (StoreReader, uint64, error) {
+	i := idx / s.m.shardEntries()
+	if i >= uint64(len(s.shards)) {
+		return nil, 0, errEntryNotFound
+	}
+	return s.shards[i], idx % s.m.shardEntries(), nil
+}
+
+func (s *shardedStoreReader) ReadUint64(idx uint64) (uint64, error) {
+	r, i, err := s.shard(idx)
+	if err != nil {
+		return 0, err
+	}
+	return r.ReadUint64(i)
+}
+
+// Read the entries at indices with one batch per shard
+func (s *shardedStoreReader) ReadUint64Batch(indices []uint64) ([]uint64, error) {
+
+	// shard entries indices and their indices in indices
+	shardIndices := make([][]uint64, len(s.shards))
+	order := make([][]int, len(s.shards))
+
+	for i, idx := range indices {
+		n := idx / s.m.shardEntries()
+		if n >= uint64(len(s.shards)) {
+			return nil, errEntryNotFound
+		}
+		shardIndices[n] = append(shardIndices[n], idx%s.m.shardEntries())
+		order[n] = append(order[n], i)
+	}
+
+	res := make([]uint64, len(indices))
+	for n, r := range s.shards {
+		if len(shardIndices[n]) == 0 {
+			continue
+		}
+
+		values, err := r.ReadUint64Batch(shardIndices[n])
+		if err != nil {
+			return nil, err
+		}
+
+		for j, v := range values {
+			res[order[n][j]] = v
+		}
+	}
+
+	return res, nil
+}
+
+func (s *shardedStoreReader) ReadBytes(idx uint64) ([]byte, error) {
+	v, err := s.ReadUint64(idx)
+	if err != nil {
+		return nil, err
+	}
+	return util.EncodeToBytes(v), nil
+}
+
+func (s *shardedStoreReader) Read(idx uint64) (bitarray.BitArray, error) {
+	r, i, err := s.shard(idx)
+	if err != nil {
+		return bitarray.NewBitArray(uint64(s.m.L), false), err
+	}
+	return r.Read(i)
+}
+
+func (s *shardedStoreReader) Close() error {
+	var res error
+	for _, r := range s.shards {
+		err := r.Close()
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// Returns the manifest file name
+func (s *shardedStoreReader) FileName() string {
+	return s.fileName
+}
@@ -0,0 +1,275 @@
+package post
+
+import (
+	"errors"
+	"strconv"
+)
+
+// A merkle tree store split into the shards of a manifest
+// The nodes at depth ShardBits and below are in the subtree store of their shard and the nodes above them are in
+// the top levels store. A node of a shard subtree is identified by its id without its first ShardBits bits
+
+type shardedTreeStore struct {
+	bits   uint // shard bits
+	top    TreeStoreWriter
+	shards []TreeStoreWriter
+}
+
+type shardedTreeStoreReader struct {
+	bits   uint // shard bits
+	top    TreeStoreReader
+	shards []TreeStoreReader
+}
+
+// Create the merkle tree files of the shards of m for the table described by hdr. Any existing file is truncated
+// The omitted levels of hdr must be in the shards subtrees
+func NewShardedTreeStoreWriter(m *Manifest, hdr *Header) (TreeStoreWriter, error) {
+
+	if m.N != hdr.N || m.L != hdr.L {
+		return nil, errors.New("manifest was created for different table params")
+	}
+
+	if uint64(hdr.OmittedLevels)+uint64(m.ShardBits) >= m.N {
+		return nil, errors.New("omitted merkle tree levels must be below the shards subtrees roots")
+	}
+
+	res := &shardedTreeStore{bits: m.ShardBits}
+
+	var err error
+	if m.MerkleTop != "" {
+		res.top, err = NewTreeStoreWriter(m.path(m.MerkleTop), m.topHeader(hdr))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range m.Shards {
+		w, err := NewTreeStoreWriter(m.path(s.Merkle), m.shardHeader(hdr))
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.shards = append(res.shards, w)
+	}
+
+	return res, nil
+}
+
+// Returns the shard of node id and the node id in the shard subtree. The shard is -1 for the top levels nodes
+func shardNode(id Identifier, bits uint) (int, Identifier, error) {
+	if uint(len(id)) < bits {
+		return -1, id, nil
+	}
+	if bits == 0 {
+		return 0, id, nil
+	}
+
+	s, err := strconv.ParseUint(string(id[:bits]), 2, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return int(s), id[bits:], nil
+}
+
+// Returns the store of node id and the node id in it
+func (d *shardedTreeStore) store(id Identifier) (TreeStoreWriter, Identifier, error) {
+	s, sid, err := shardNode(id, d.bits)
+	if err != nil {
+		return nil, "", err
+	}
+	if s < 0 {
+		return d.top, sid, nil
+	}
+	return d.shards[s], sid, nil
+}
+
+// Returns all the stores of the tree
+func (d *shardedTreeStore) stores() []TreeStoreWriter {
+	if d.top == nil {
+		return d.shards
+	}
+	return append([]TreeStoreWriter{d.top}, d.shards...)
+}
+
+func (d *shardedTreeStore) Write(id Identifier, l Label) error {
+	w, sid, err := d.store(id)
+	if err != nil {
+		return err
+	}
+	return w.Write(sid, l)
+}
+
+func (d *shardedTreeStore) IsLabelInStore(id Identifier) (bool, error) {
+	w, sid, err := d.store(id)
+	if err != nil {
+		return false, err
+	}
+	return w.IsLabelInStore(sid)
+}
+
+func (d *shardedTreeStore) Reset() error {
+	for _, w := range d.stores() {
+		err := w.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *shardedTreeStore) Delete() error {
+	for _, w := range d.stores() {
+		err := w.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *shardedTreeStore) Size() uint64 {
+	var res uint64
+	for _, w := range d.stores() {
+		res += w.Size()
+	}
+	return res
+}
+
+func (d *shardedTreeStore) Finalize() error {
+	for _, w := range d.stores() {
+		err := w.Finalize()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *shardedTreeStore) Close() error {
+	var res error
+	for _, w := range d.stores() {
+		err := w.Close()
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// Open the merkle tree files of the shards of the manifest fileName for reading
+func NewShardedTreeStoreReader(fileName string, opts ...ReaderOption) (TreeStoreReader, error) {
+
+	m, err := ReadManifest(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &shardedTreeStoreReader{bits: m.ShardBits}
+
+	if m.MerkleTop != "" {
+		res.top, err = NewTreeStoreReader(m.path(m.MerkleTop), m.ShardBits-1, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range m.Shards {
+		r, err := NewTreeStoreReader(m.path(s.Merkle), uint(m.N-uint64(m.ShardBits)-1), opts...)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.shards = append(res.shards, r)
+	}
+
+	return res, nil
+}
+
+// Returns the index of the store of node id and the node id in it. The top levels store index is len(shards)
+func (d *shardedTreeStoreReader) store(id Identifier) (int, Identifier, error) {
+	s, sid, err := shardNode(id, d.bits)
+	if err != nil {
+		return 0, "", err
+	}
+	if s < 0 {
+		return len(d.shards), sid, nil
+	}
+	if s >= len(d.shards) {
+		return 0, "", errors.New("invalid node id")
+	}
+	return s, sid, nil
+}
+
+// Returns the store at index i
+func (d *shardedTreeStoreReader) reader(i int) TreeStoreReader {
+	if i == len(d.shards) {
+		return d.top
+	}
+	return d.shards[i]
+}
+
+func (d *shardedTreeStoreReader) Read(id Identifier) (Label, error) {
+	i, sid, err := d.store(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.reader(i).Read(sid)
+}
+
+// Read the labels of ids with one batch per store
+func (d *shardedTreeStoreReader) ReadBatch(ids []Identifier) (Labels, error) {
+
+	// stores ids and their indices in ids
+	storeIds := make([][]Identifier, len(d.shards)+1)
+	order := make([][]int, len(d.shards)+1)
+
+	for j, id := range ids {
+		i, sid, err := d.store(id)
+		if err != nil {
+			return nil, err
+		}
+		storeIds[i] = append(storeIds[i], sid)
+		order[i] = append(order[i], j)
+	}
+
+	res := make(Labels, len(ids))
+	for i := range storeIds {
+		if len(storeIds[i]) == 0 {
+			continue
+		}
+
+		labels, err := d.reader(i).ReadBatch(storeIds[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j, l := range labels {
+			res[order[i][j]] = l
+		}
+	}
+
+	return res, nil
+}
+
+func (d *shardedTreeStoreReader) Size() uint64 {
+	var res uint64
+	for i := 0; i <= len(d.shards); i++ {
+		if r := d.reader(i); r != nil {
+			res += r.Size()
+		}
+	}
+	return res
+}
+
+func (d *shardedTreeStoreReader) Close() error {
+	var res error
+	for i := 0; i <= len(d.shards); i++ {
+		if r := d.reader(i); r != nil {
+			err := r.Close()
+			if err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
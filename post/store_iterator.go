@@ -0,0 +1,76 @@
+package post
+
+import (
+	"github.com/icza/bitio"
+	"io"
+	"math"
+)
+
+// entryIterator reads store entries sequentially
+type entryIterator interface {
+	next() (uint64, error)
+	close() error
+}
+
+// the bitio reader methods used by the file iterator
+type bitReader interface {
+	ReadBits(n uint8) (uint64, error)
+}
+
+// Returns an iterator over the entries of sr starting at entry start
+// Entries of a store are streamed from its backend. Any other reader is read entry by entry
+func newEntryIterator(sr StoreReader, start uint64) (entryIterator, error) {
+	if s, ok := sr.(*store); ok {
+		return newFileIterator(s, start)
+	}
+	return &readerIterator{sr, start}, nil
+}
+
+// Sequential buffered reader of the entries of a store file
+type fileIterator struct {
+	r bitReader
+	n uint8 // bits per entry
+}
+
+func newFileIterator(s *store, start uint64) (entryIterator, error) {
+
+	offsetBits := start * uint64(s.n)
+
+	// read from the byte containing the first bit of entry start and skip the bits before it
+	sr := io.NewSectionReader(s.backend, int64(HeaderSize+offsetBits/8), math.MaxInt64-HeaderSize-int64(offsetBits/8))
+	it := &fileIterator{bitio.NewReader(sr), uint8(s.n)}
+
+	if skip := uint8(offsetBits % 8); skip > 0 {
+		_, err := it.r.ReadBits(skip)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return it, nil
+}
+
+func (it *fileIterator) next() (uint64, error) {
+	return it.r.ReadBits(it.n)
+}
+
+// the store file is owned by the store reader
+func (it *fileIterator) close() error {
+	return nil
+}
+
+// Iterator over the entries of any store reader
+type readerIterator struct {
+	sr  StoreReader
+	idx uint64
+}
+
+func (it *readerIterator) next() (uint64, error) {
+	v, err := it.sr.ReadUint64(it.idx)
+	it.idx++
+	return v, err
+}
+
+func (it *readerIterator) close() error {
+	return nil
+}
@@ -15,6 +15,12 @@ func dumpContent(filePath string, lineLength uint) error {
 	}
 	defer f.Close()
 
+	// skip the file header
+	_, err = f.Seek(HeaderSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
 	data := make([]byte, 4096)
 	reader := bufio.NewReader(f)
 	charIdx := uint(0)
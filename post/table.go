@@ -1,39 +1,497 @@
 package post
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/avive/rpost/hashing"
 	"github.com/avive/rpost/util"
 	"math"
 	"math/big"
-	"math/bits"
+	"os"
+	"sync"
 )
 
 type Table struct {
-	id []byte           // initial commitment
-	n  uint64           // n param 1 <= n <= 63 - table size is 2^n
-	l  uint             // l param (num of leading 0s for p) := f(p). 1: 50%, 2: 25%, 3:12.5%... l:= log2(1/p)
+	p  *Params          // validated params of the table. e.g. id, n and l
 	h  hashing.HashFunc // Hx()
+	lb Labeling         // table entries labeling function
 	s  StoreWriter
+	sb Backend // post store backend the commitment is written to. nil for memory and sharded tables
+	cb Backend // checkpoint backend. nil for memory tables
+
+	workers uint        // # of goroutines used to generate the table. 0 or 1 for serial generation
+	start   uint64      // index of the first entry to generate - non-zero when resuming a partially written store
+	cp      *checkpoint // initialization progress
+
+	acc     MerkleAccumulator // merkle tree builder fed with generated entries. nil when only generating the store
+	omitted uint              // # of lowest merkle tree levels which labels are not stored
+	layout  TreeLayout        // merkle tree file labels layout
+
+	log      Logger         // debug output. Silent when nil
+	progress ProgressFunc   // called with the generation progress every progressInterval entries. nil for none
+	meter    *progressMeter // progress of the current generation
+	written  uint64         // # of entries in the store
 }
 
+// # of table entries searched by a worker in one batch
+const genBatchSize = 1024
+
 // Create a new prover with commitment X and param
 // n:=  9 <= n <= 63
 // l:= 1 <= l <= 63
 func NewTable(id []byte, n uint64, l uint, h hashing.HashFunc, filePath string) (*Table, error) {
+	return NewTableWithLabeling(id, n, l, h, Labeling{}, filePath)
+}
+
+// Create a new table which entries are labeled using lb
+func NewTableWithLabeling(id []byte, n uint64, l uint, h hashing.HashFunc, lb Labeling, filePath string) (*Table, error) {
+
+	p, err := NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	sb, cb, err := openTableFiles(filePath, os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := newTable(p, h, lb, sb, cb)
+	if err != nil {
+		sb.Close()
+		cb.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Create a new table with params p which entries are labeled using lb
+func NewTableWithParams(p *Params, lb Labeling, filePath string) (*Table, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+	return NewTableWithLabeling(p.Id, p.N, p.L, h, lb, filePath)
+}
+
+// Create a new table with params p which entries are labeled using lb
+// The post store is written to sb and the checkpoint to cb. Any existing data is discarded
+// The table owns sb and cb and closes them when the store phase ends. They are left open on error
+func NewTableWithBackends(p *Params, lb Labeling, sb Backend, cb Backend) (*Table, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return newTable(p, h, lb, sb, cb)
+}
+
+// Open the post store file at filePath and its checkpoint file as backends. flag is added to the store file flags
+func openTableFiles(filePath string, flag int) (Backend, Backend, error) {
+	sb, err := openFileBackend(filePath, os.O_RDWR|os.O_CREATE|flag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cb, err := openCheckpointBackend(filePath)
+	if err != nil {
+		sb.Close()
+		return nil, nil, err
+	}
+
+	return sb, cb, nil
+}
+
+// Create a new table which post store is written to sb and its checkpoint to cb
+func newTable(p *Params, h hashing.HashFunc, lb Labeling, sb Backend, cb Backend) (*Table, error) {
+
+	table := &Table{p: p, h: h, lb: lb, sb: sb, cb: cb}
+
+	// the store is closed when all entries are written. The commitment is written to sb after that
+	var err error
+	table.s, err = NewStoreWriterWithBackend(nopCloseBackend{sb}, table.header())
+	if err != nil {
+		return nil, err
+	}
+
+	table.cp = &checkpoint{Id: p.Id, N: p.N, L: p.L, Hash: h.Id(), Labeling: lb}
+	err = writeCheckpoint(cb, table.cp)
+	if err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Create a new table which entries are written to the in-memory store s
+// Nothing is written to disk so the table initialization can't be resumed
+func NewMemoryTable(id []byte, n uint64, l uint, h hashing.HashFunc, lb Labeling, s *MemoryStore) (*Table, error) {
+
+	p, err := NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.n != l || s.bits != 0 {
+		return nil, errors.New("memory store must be empty and store l bits entries")
+	}
+
+	return &Table{p: p, h: h, lb: lb, s: s,
+		cp: &checkpoint{Id: id, N: n, L: l, Hash: h.Id(), Labeling: lb}}, nil
+}
+
+// Create a new table which entries are written to the shards of m
+// The manifest is written to manifestFile and the table checkpoint is written next to it. Use StoreSharded() to
+// write the merkle tree to the shards
+func NewShardedTable(id []byte, n uint64, l uint, h hashing.HashFunc, lb Labeling, m *Manifest,
+	manifestFile string) (*Table, error) {
+
+	p, err := NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &Table{p: p, h: h, lb: lb}
+
+	table.s, err = NewShardedStoreWriter(manifestFile, m, table.header())
+	if err != nil {
+		return nil, err
+	}
+
+	table.cb, err = openCheckpointBackend(manifestFile)
+	if err != nil {
+		table.s.Close()
+		return nil, err
+	}
+
+	table.cp = &checkpoint{Id: id, N: n, L: l, Hash: h.Id(), Labeling: lb}
+	err = writeCheckpoint(table.cb, table.cp)
+	if err != nil {
+		table.s.Close()
+		table.cb.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Open a sharded table for resuming a partially completed initialization using the checkpoint next to manifestFile
+// A new table is created with m if there is no checkpoint. Otherwise the manifest in manifestFile must have the
+// shards of m. Generation resumes from the last byte aligned entry written to the shards
+// Returns ErrCheckpointMismatch if the shards were created with different id, n, l, hash backend or labeling
+func OpenShardedTable(id []byte, n uint64, l uint, h hashing.HashFunc, lb Labeling, m *Manifest,
+	manifestFile string) (*Table, error) {
+
+	p, err := NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
 
-	// todo: check precondition args values here
+	cb, err := openCheckpointBackend(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := readCheckpoint(cb)
+	if err != nil {
+		cb.Close()
+		return nil, err
+	}
+
+	if cp == nil {
+		cb.Close()
+		return NewShardedTable(id, n, l, h, lb, m, manifestFile)
+	}
+
+	table, err := openShardedTable(p, h, lb, cp, cb, m, manifestFile)
+	if err != nil {
+		cb.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Open the shards of the manifest manifestFile for resuming the initialization of a table with checkpoint cp
+func openShardedTable(p *Params, h hashing.HashFunc, lb Labeling, cp *checkpoint, cb Backend, m *Manifest,
+	manifestFile string) (*Table, error) {
+
+	if !cp.matches(p.Id, p.N, p.L, h.Id(), lb) {
+		return nil, ErrCheckpointMismatch
+	}
+
+	m1, err := ReadManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !m1.sameShards(m) {
+		return nil, errors.New("manifest shards changed and the table can't be resumed")
+	}
+
+	// # of entries in the shards
+	entries, err := m1.storedEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	if cp.Entries < entries {
+		entries = cp.Entries
+	}
+
+	// resume from the last entry ending on a byte boundary
+	for entries*uint64(p.L)%8 != 0 {
+		entries--
+	}
+
+	if entries < cp.Entries || cp.Commitment == nil {
+		// the merkle tree of the shards is rewritten from the stored entries
+		cp.Entries = entries
+		cp.MerkleLabels = 0
+		cp.Commitment = nil
+		m1.Commitment = nil
+	}
+
+	table := &Table{p: p, h: h, lb: lb, start: entries, cp: cp, cb: cb}
+
+	table.s, err = OpenShardedStoreWriter(manifestFile, m1, table.header(), entries)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeCheckpoint(cb, cp)
+	if err != nil {
+		table.s.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Open a table for resuming a partially completed initialization using the checkpoint of the store at filePath
+// Generation resumes from the last byte aligned entry written to the store and the merkle tree from the
+// last label written to its file. A new table is created if there is no store at filePath
+// Returns ErrCheckpointMismatch if the store was created with different id, n, l, hash backend or labeling
+func OpenTable(id []byte, n uint64, l uint, h hashing.HashFunc, filePath string) (*Table, error) {
+	return OpenTableWithLabeling(id, n, l, h, Labeling{}, filePath)
+}
+
+// Open a table which entries are labeled using lb for resuming a partially completed initialization
+func OpenTableWithLabeling(id []byte, n uint64, l uint, h hashing.HashFunc, lb Labeling, filePath string) (*Table, error) {
+
+	p, err := NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("Store file: %s\n", filePath)
+	sb, cb, err := openTableFiles(filePath, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := openTable(p, h, lb, sb, cb)
+	if err != nil {
+		sb.Close()
+		cb.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Open a table with params p which entries are labeled using lb for resuming a partially completed initialization
+func OpenTableWithParams(p *Params, lb Labeling, filePath string) (*Table, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+	return OpenTableWithLabeling(p.Id, p.N, p.L, h, lb, filePath)
+}
+
+// Open a table with params p which entries are labeled using lb for resuming a partially completed initialization
+// from the post store in sb and the checkpoint in cb. A new table is created if both are empty
+// The table owns sb and cb and closes them when the store phase ends. They are left open on error
+func OpenTableWithBackends(p *Params, lb Labeling, sb Backend, cb Backend) (*Table, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = lb.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return openTable(p, h, lb, sb, cb)
+}
+
+// Open a table which post store is in sb and its checkpoint in cb for resuming its initialization
+func openTable(p *Params, h hashing.HashFunc, lb Labeling, sb Backend, cb Backend) (*Table, error) {
+
+	cp, err := readCheckpoint(cb)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := sb.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	if cp == nil {
+		if size > 0 {
+			return nil, errors.New("store has no checkpoint and can't be resumed")
+		}
+		return newTable(p, h, lb, sb, cb)
+	}
+
+	if !cp.matches(p.Id, p.N, p.L, h.Id(), lb) {
+		return nil, ErrCheckpointMismatch
+	}
+
+	// # of entries in the store
+	var entries uint64
+	if size > HeaderSize {
+		entries = uint64(size-HeaderSize) * 8 / uint64(p.L)
+	}
+
+	if cp.Entries < entries {
+		entries = cp.Entries
+	}
+
+	// resume from the last entry ending on a byte boundary
+	for entries*uint64(p.L)%8 != 0 {
+		entries--
+	}
+
+	if entries < cp.Entries {
+		// store is not complete so any merkle tree progress is lost
+		cp.Entries = entries
+		cp.MerkleLabels = 0
+		cp.Commitment = nil
+	}
+
+	table := &Table{p: p, h: h, lb: lb, start: entries, cp: cp, sb: sb, cb: cb}
+
+	table.s, err = OpenStoreWriterWithBackend(nopCloseBackend{sb}, table.header(), entries)
+	if err != nil {
+		return nil, err
+	}
 
-	store, err := NewStoreWriter(filePath, l)
+	err = writeCheckpoint(cb, cp)
 	if err != nil {
 		return nil, err
+	}
+
+	return table, nil
+}
+
+// Returns the params of the table
+func (t *Table) Params() *Params {
+	return t.p
+}
+
+// Returns the header of the table's store and merkle tree files
+func (t *Table) header() *Header {
+	hdr := t.p.Header()
+	hdr.Labeling = t.lb
+	return hdr
+}
+
+// Set the # of lowest merkle tree levels which labels are not stored in the merkle tree file
+// Omitting k levels shrinks the merkle tree file by a factor of about 2^k while each proof path recomputes
+// subtrees of up to 2^(k+1) store entries from the post store. Defaults to 0 - all labels are stored
+func (t *Table) SetOmittedLevels(k uint) error {
+	if k > 0 && uint64(k) >= t.p.N {
+		return fmt.Errorf("# of omitted merkle tree levels must be smaller than %d", t.p.N)
+	}
+	t.omitted = k
+	return nil
+}
+
+// Set the labels layout of the merkle tree file. Defaults to LayoutDepthFirst
+func (t *Table) SetMerkleLayout(layout TreeLayout) {
+	t.layout = layout
+}
 
+// Returns the header of the table's merkle tree file
+func (t *Table) merkleHeader() *Header {
+	hdr := t.header()
+	hdr.OmittedLevels = t.omitted
+	hdr.Layout = t.layout
+	return hdr
+}
+
+// Set the logger receiving the debug output of the table generation. The table is silent by default
+func (t *Table) SetLogger(l Logger) {
+	t.log = l
+}
+
+// Set a func called with the progress of the table generation every 1024 generated entries and when done
+func (t *Table) SetProgress(f ProgressFunc) {
+	t.progress = f
+}
+
+func (t *Table) logf(format string, v ...interface{}) {
+	if t.log != nil {
+		t.log.Printf(format, v...)
 	}
-	table := Table{id, n, l, h, store}
-	return &table, nil
+}
+
+// Set the number of goroutines used to generate the table
+// The generated store is identical for any number of workers
+func (t *Table) SetWorkers(workers uint) {
+	t.workers = workers
 }
 
 var one = big.NewInt(1)
@@ -43,28 +501,94 @@ var one = big.NewInt(1)
 // Implements the Store phase of rpost (page 9)
 // Stores the data and the merkle tree
 func (t *Table) Store(merkleFilePath string) ([]byte, error) {
+	return t.StoreContext(context.Background(), merkleFilePath)
+}
+
+// Store the data and the merkle tree until done or ctx is done
+// When ctx is done the store and merkle tree files are flushed and closed, the checkpoint is saved and ctx.Err() is
+// returned. The initialization can be resumed by opening the table with OpenTable()
+func (t *Table) StoreContext(ctx context.Context, merkleFilePath string) ([]byte, error) {
+	b, err := openFileBackend(merkleFilePath, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	return t.StoreWithBackend(ctx, b)
+}
 
-	// 1. Generate and store the values of the iPoW table G
-	_, err := t.Generate(false)
+// Store the data and the merkle tree to the tree store in b until done or ctx is done
+// The table owns b and closes it. The commitment is written to the headers of the post store and of the tree store
+func (t *Table) StoreWithBackend(ctx context.Context, b Backend) ([]byte, error) {
+
+	comm, err := t.store(ctx, b)
 	if err != nil {
+		t.closeBackends()
 		return nil, err
 	}
 
-	// 2. Generate the Merkle store
+	return comm, t.closeBackends()
+}
+
+func (t *Table) store(ctx context.Context, b Backend) ([]byte, error) {
+
+	if _, ok := t.s.(*shardedStoreWriter); ok {
+		b.Close()
+		return nil, errors.New("the merkle tree of a sharded table is written by StoreSharded()")
+	}
+
+	if t.cp.Commitment != nil {
+		// table was fully initialized before
+		b.Close()
+		return t.cp.Commitment, t.s.Close()
+	}
+
+	// resume from labels already in the merkle tree store
+	labels := t.cp.MerkleLabels
+	size, err := b.Size()
+	if err != nil || size < HeaderSize {
+		labels = 0
+	} else if uint64(size-HeaderSize)/uint64(WB) < labels {
+		labels = uint64(size-HeaderSize) / uint64(WB)
+	}
+
+	// labels written with other omitted levels or layout can't be resumed from
+	if labels > 0 {
+		if mh, err := readHeader(b, merkleMagic); err != nil || mh.OmittedLevels != t.omitted || mh.Layout != t.layout {
+			labels = 0
+		}
+	}
+
+	// labels can only cover entries already in the store
+	if max := postOrderSize(t.start/2, t.omitted); labels > max {
+		labels = max
+	}
+
+	progress := func(labels uint64) error {
+		t.cp.MerkleLabels = labels
+		return t.saveCheckpoint()
+	}
 
-	// test merkle tree from post store
-	sr, err := NewStoreReader(t.s.FileName(), t.l)
+	// 1. Open the Merkle tree builder
+	acc, err := OpenMerkleAccumulatorWithBackend(b, t.merkleHeader(), t.h, labels, progress)
 	if err != nil {
+		b.Close()
 		return nil, err
 	}
 
-	// Merkle file writer
-	mw, err := NewMerkleTreeWriter(sr, merkleFilePath, t.l, uint(t.n), t.h)
+	// 2. Generate and store the values of the iPoW table G and the Merkle tree
+	comm, err := t.build(ctx, acc)
 	if err != nil {
 		return nil, err
 	}
 
-	comm, err := mw.Write()
+	if t.sb != nil {
+		err = writeCommitment(t.sb, comm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.cp.Commitment = comm
+	err = t.saveCheckpoint()
 	if err != nil {
 		return nil, err
 	}
@@ -72,95 +596,511 @@ func (t *Table) Store(merkleFilePath string) ([]byte, error) {
 	return comm, nil
 }
 
+// Implements the Store phase of rpost for a table created with NewMemoryTable()
+// Returns the commitment and the in-memory store of the merkle tree
+func (t *Table) StoreInMemory() ([]byte, *MemoryTreeStore, error) {
+
+	if _, ok := t.s.(*MemoryStore); !ok {
+		return nil, nil, errors.New("table store is not in memory")
+	}
+
+	ts := NewMemoryTreeStore(t.merkleHeader())
+
+	comm, err := t.build(context.Background(), NewMerkleAccumulator(ts, t.merkleHeader(), t.h))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.cp.Commitment = comm
+	return comm, ts, nil
+}
+
+// Implements the Store phase of rpost for a table created with NewShardedTable() or OpenShardedTable()
+// The merkle tree is written to the shards merkle tree files and the commitment is recorded in the manifest
+func (t *Table) StoreSharded() ([]byte, error) {
+	return t.StoreShardedContext(context.Background())
+}
+
+// Store the data and the merkle tree of a sharded table until done or ctx is done
+// When ctx is done the shards are flushed and closed, the checkpoint is saved and ctx.Err() is returned. Writing the
+// store can be resumed by opening the table with OpenShardedTable(). The merkle tree is rewritten from the stored entries
+func (t *Table) StoreShardedContext(ctx context.Context) ([]byte, error) {
+
+	comm, err := t.storeSharded(ctx)
+	if err != nil {
+		t.closeBackends()
+		return nil, err
+	}
+
+	return comm, t.closeBackends()
+}
+
+func (t *Table) storeSharded(ctx context.Context) ([]byte, error) {
+
+	ss, ok := t.s.(*shardedStoreWriter)
+	if !ok {
+		return nil, errors.New("table store is not sharded")
+	}
+
+	if t.cp.Commitment != nil {
+		// table was fully initialized before
+		return t.cp.Commitment, t.s.Close()
+	}
+
+	w, err := NewShardedTreeStoreWriter(ss.m, t.merkleHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	comm, err := t.build(ctx, NewMerkleAccumulator(w, t.merkleHeader(), t.h))
+	if err != nil {
+		return nil, err
+	}
+
+	ss.m.Commitment = comm
+	err = WriteManifest(ss.fileName, ss.m)
+	if err != nil {
+		return nil, err
+	}
+
+	t.cp.Commitment = comm
+	return comm, t.saveCheckpoint()
+}
+
+// Generate the table entries and add them to the merkle tree. Returns the merkle root
+// When ctx is done the labels written so far are flushed and the merkle tree store is closed
+func (t *Table) build(ctx context.Context, acc MerkleAccumulator) ([]byte, error) {
+
+	// add entries in the store which labels are not in the merkle tree
+	err := t.addStoredEntries(ctx, acc)
+	if err == ctx.Err() && err != nil {
+		err = t.stop(t.start, err)
+	}
+
+	if err == nil {
+		// Each entry is added to the merkle tree when written
+		t.acc = acc
+		_, err = t.generate(ctx, false)
+		t.acc = nil
+	}
+
+	if err != nil {
+		// flush the labels written so far and close the merkle tree file. e.g. when the disk is full
+		cerr := acc.Close()
+		if cerr != nil && err == ctx.Err() {
+			return nil, cerr
+		}
+		return nil, err
+	}
+
+	return acc.Commit()
+}
+
+// Write the checkpoint to the checkpoint backend. Tables in memory have no checkpoint
+func (t *Table) saveCheckpoint() error {
+	if t.cb == nil {
+		return nil
+	}
+	return writeCheckpoint(t.cb, t.cp)
+}
+
+// Close the post store and checkpoint backends when the store phase ends
+func (t *Table) closeBackends() error {
+	var err error
+	if t.sb != nil {
+		err = t.sb.Close()
+		t.sb = nil
+	}
+	if t.cb != nil {
+		if cerr := t.cb.Close(); err == nil {
+			err = cerr
+		}
+		t.cb = nil
+	}
+	return err
+}
+
+// Add the entries written to the store before generation was resumed to the merkle tree
+func (t *Table) addStoredEntries(ctx context.Context, acc MerkleAccumulator) error {
+	if acc.Entries() >= t.start {
+		return nil
+	}
+
+	// the entries are read through the store writer. Nothing was appended to it yet
+	sr, ok := t.s.(StoreReader)
+	if ss, sharded := t.s.(*shardedStoreWriter); sharded {
+		var err error
+		sr, err = ss.reader()
+		if err != nil {
+			return err
+		}
+	} else if !ok {
+		return errors.New("table store can't be read")
+	}
+
+	it, err := newEntryIterator(sr, acc.Entries())
+	if err != nil {
+		return err
+	}
+
+	for i := acc.Entries(); i < t.start; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		v, err := it.next()
+		if err != nil {
+			return err
+		}
+
+		err = acc.Add(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return it.close()
+}
+
+// Generate the table and write it to the store
+// When resuming a partially written store only the missing entries are generated and returned
 func (t *Table) Generate(returnData bool) ([]uint64, error) {
+	return t.GenerateContext(context.Background(), returnData)
+}
+
+// Generate the table until done or ctx is done
+// When ctx is done the store is flushed and closed, the checkpoint is saved and ctx.Err() is returned
+func (t *Table) GenerateContext(ctx context.Context, returnData bool) ([]uint64, error) {
+	res, err := t.generate(ctx, returnData)
+
+	// nothing else is written to the table backends when only the store is generated
+	cerr := t.closeBackends()
+	if err != nil {
+		return nil, err
+	}
+	return res, cerr
+}
 
-	n := uint64(math.Pow(2, float64(t.n)))
-	fmt.Printf("Table size: %d \n", n)
+func (t *Table) generate(ctx context.Context, returnData bool) ([]uint64, error) {
+
+	n := uint64(math.Pow(2, float64(t.p.N)))
+	t.logf("Table size: %d", n)
+
+	if t.start > 0 {
+		t.logf("Resuming store %s from entry %d", t.s.FileName(), t.start)
+	}
 
 	// p*
-	phi := K / float64(n)
-	fmt.Printf("P*: %f \n", phi)
+	phi := float64(K) / float64(n)
+	t.logf("P*: %f", phi)
 
 	// compute probability in (0...1)
-	p := util.GetProbability(t.l)
-	fmt.Printf("Difficulty p: %.30f\n", p)
+	p := util.GetProbability(t.p.L)
+	t.logf("Difficulty p: %.30f", p)
 
 	l1 := util.GetDifficulty(p)
-	fmt.Printf("Difficulty l: %d\n", l1)
+	t.logf("Difficulty l: %d", l1)
 
-	fmt.Printf("Expected hashes to find a digest is at least %d hash ops\n", int(1/p))
+	t.logf("Expected hashes to find a digest is at least %d hash ops", int(1/p))
 
-	maxNonceVal := big.NewInt(int64(math.Ceil(K / p)))
-	fmt.Printf("Max permitted nonce: %s\n", maxNonceVal.String())
+	maxNonceVal := GetMaxNonce(t.p.L)
+	t.logf("Max permitted nonce: %s", maxNonceVal.String())
 
-	fmt.Printf("Commitment x: 0x%x\n", t.id)
+	t.logf("Commitment x: 0x%x", t.p.Id)
 
 	// number of bites to store per hash is same as l
-	//bits := uint(math.Ceil(math.Log2(1 / p))) === t.l
-	fmt.Printf("Number of nonce bits to store : %d\n", t.l)
-	fmt.Printf("Difficulty param : %d\n", t.l)
+	//bits := uint(math.Ceil(math.Log2(1 / p))) === t.p.L
+	t.logf("Number of nonce bits to store : %d", t.p.L)
+	t.logf("Difficulty param : %d", t.p.L)
 
-	// create a bit mask of t.l bits set to 1
-	storeMask := util.GetSimpleMask(t.l)
-	fmt.Printf("Store mask bit field : %d %b\n", storeMask, storeMask)
+	// create a bit mask of t.p.L bits set to 1
+	storeMask := util.GetSimpleMask(t.p.L)
+	t.logf("Store mask bit field : %d %b", storeMask, storeMask)
 
-	m := util.GetMask(32, t.l)
-	fmt.Printf("Mask : %s\n", m.String())
+	m := util.GetMask(uint(t.h.Size()), t.p.L)
+	t.logf("Mask : %s", m.String())
 
-	nonce := big.NewInt(0)
-	d := new(big.Int)
+	t.meter = newProgressMeter(t.start, n)
+	t.written = t.start
+
+	if t.workers > 1 {
+		res, err := t.generateParallel(ctx, n, m, maxNonceVal, storeMask, returnData)
+		if err == ctx.Err() && err != nil {
+			return nil, t.stop(t.written, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, t.finalize()
+	}
 
 	var res []uint64
 
-	for i := uint64(0); i < n; i++ {
+	for i := t.start; i < n; i++ {
+
+		if err := ctx.Err(); err != nil {
+			return nil, t.stop(i, err)
+		}
+
+		nonce, _, err := findNonce(t.h, t.lb, i, m, maxNonceVal)
+		if err != nil {
+			return nil, err
+		}
+
+		// nonces 0 to nonce were hashed
+		t.meter.hashes += nonce.Uint64() + 1
+
+		// Take l lsb bits from nonce and decode to uint64
+		data := nonce.And(nonce, storeMask).Uint64()
+
+		// Write the data to the file - exactly t.p.L lsb bits of data
+		// if t.p.L > len(data) then 0s are padded starting MSB bit
+		// so, for example, if len(data) = 16 and t.p.L = 20, 4 leading 0s will be written starting at MSB bit (left-to-right)
+		// and the 16 bits of data next using big-endian encoding. e.g. MSB bit first...
+		err = t.writeEntry(data, i+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if returnData { // append to in-memory result - used for testing
+			res = append(res, data)
+		}
+	}
 
-		// nonce is in {0,1}^log(k/p) - max nonce value is k/p
-		nonce = nonce.SetUint64(0)
+	return res, t.finalize()
+}
 
-		// big endian variable size buffer of i
-		iBuf := util.EncodeToBytes(i)
+// a contiguous range of table entries [start, end) searched by a worker
+type genBatch struct {
+	start uint64
+	end   uint64
+	res   chan genResult
+}
 
-		for {
+type genResult struct {
+	data   []uint64
+	hashes uint64 // # of iPoW hashes computed
+	err    error
+}
 
-			digest := t.h.Hash(iBuf, nonce.Bytes())
-			d = d.SetBytes(digest)
+// Generate the table using t.workers goroutines. Each worker searches nonces for a contiguous range of entries
+// and the results are written to the store in table order so the store is identical to the one written by
+// a serial generation
+func (t *Table) generateParallel(ctx context.Context, n uint64, m *big.Int, maxNonceVal *big.Int, storeMask *big.Int,
+	returnData bool) ([]uint64, error) {
 
-			if d.Cmp(m) <= 0 { // H(id, i, x) < p
-				fmt.Printf("[%d]: Nonce: %d %b. Digest: 0x%x\n", i, nonce.Uint64(), nonce.Uint64(), digest)
+	t.logf("Generating table using %d workers", t.workers)
 
-				// Take l lsb bits from nonce and decode to uint64
-				data := nonce.And(nonce, storeMask).Uint64()
+	// max # of batches being searched or waiting to be written
+	window := int(t.workers) * 2
 
-				fmt.Printf("Data (%d lsb bits of nonce): %d %b bits:%d \n", t.l, data, data, bits.Len64(data))
+	// HashFunc is not safe for concurrent use - each worker uses its own Hx()
+	hs := make([]hashing.HashFunc, t.workers)
+	for w := range hs {
+		h, err := hashing.NewHashFuncWithBackend(t.p.Id, t.h.Id())
+		if err != nil {
+			return nil, err
+		}
+		hs[w] = h
+	}
 
-				// Write the data to the file - exactly t.l lsb bits of data
-				// if t.l > len(data) then 0s are padded starting MSB bit
-				// so, for example, if len(data) = 16 and t.l = 20, 4 leading 0s will be written starting at MSB bit (left-to-right)
-				// and the 16 bits of data next using big-endian encoding. e.g. MSB bit first...
-				err := t.s.Write(data, byte(t.l))
-				if err != nil {
-					return nil, err
-				}
+	jobs := make(chan *genBatch, window)
+	var wg sync.WaitGroup
 
-				if returnData { // append to in-memory result - used for testing
-					res = append(res, data)
-				}
+	for _, h := range hs {
+		wg.Add(1)
+		go func(h hashing.HashFunc) {
+			defer wg.Done()
 
-				break
+			for b := range jobs {
+				data, hashes, err := generateRange(ctx, h, t.lb, b.start, b.end, m, maxNonceVal, storeMask)
+				b.res <- genResult{data, hashes, err}
 			}
+		}(h)
+	}
+
+	defer wg.Wait()
+	defer close(jobs)
+
+	// batches in table order
+	var pending []*genBatch
+	next := t.start
+
+	enqueue := func() {
+		if next == n {
+			return
+		}
+		end := next + genBatchSize
+		if end > n {
+			end = n
+		}
+		b := &genBatch{next, end, make(chan genResult, 1)}
+		jobs <- b
+		pending = append(pending, b)
+		next = end
+	}
+
+	for i := 0; i < window; i++ {
+		enqueue()
+	}
+
+	var res []uint64
+
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		b := pending[0]
+		pending = pending[1:]
 
-			nonce = nonce.Add(nonce, one)
+		r := <-b.res
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		t.meter.hashes += r.hashes
 
-			if nonce.Cmp(maxNonceVal) == 1 {
-				// nonce overflow. We expect nonce length to not go over ceil(k/p)
-				return nil, errors.New("failed to find nonce in permitted range ceil(k/p)")
+		for i, data := range r.data {
+			err := t.writeEntry(data, b.start+uint64(i)+1)
+			if err != nil {
+				return nil, err
 			}
 		}
+
+		if returnData {
+			res = append(res, r.data...)
+		}
+
+		enqueue()
 	}
 
-	return res, t.finalize()
+	return res, nil
+}
+
+// Returns the data to store for table entries [start, end) and the # of iPoW hashes computed
+// Returns ctx.Err() if ctx is done before all entries were generated
+func generateRange(ctx context.Context, h hashing.HashFunc, lb Labeling, start uint64, end uint64, m *big.Int, maxNonceVal *big.Int,
+	storeMask *big.Int) ([]uint64, uint64, error) {
+
+	res := make([]uint64, 0, end-start)
+	var hashes uint64
+
+	for i := start; i < end; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		nonce, _, err := findNonce(h, lb, i, m, maxNonceVal)
+		if err != nil {
+			return nil, 0, err
+		}
+		hashes += nonce.Uint64() + 1
+
+		// Take l lsb bits from nonce and decode to uint64
+		res = append(res, nonce.And(nonce, storeMask).Uint64())
+	}
+
+	return res, hashes, nil
+}
+
+// Returns the first nonce which is a valid iPoW for table entry i and its digest
+// m - the max digest value for difficulty l, maxNonceVal - the max permitted nonce value
+func findNonce(h hashing.HashFunc, lb Labeling, i uint64, m *big.Int, maxNonceVal *big.Int) (*big.Int, []byte, error) {
+
+	// nonce is in {0,1}^log(k/p) - max nonce value is k/p
+	nonce := big.NewInt(0)
+	d := new(big.Int)
+
+	for {
+		digest := GetIPoWDigest(h, lb, i, nonce)
+		d = d.SetBytes(digest)
+
+		if d.Cmp(m) <= 0 { // H(id, i, x) < p
+			return nonce, digest, nil
+		}
+
+		nonce = nonce.Add(nonce, one)
+
+		if nonce.Cmp(maxNonceVal) == 1 {
+			// nonce overflow. We expect nonce length to not go over ceil(k/p)
+			return nil, nil, errors.New("failed to find nonce in permitted range ceil(k/p)")
+		}
+	}
+}
+
+// Returns the iPoW digest of table entry i labeled using lb. e.g. Hx(i, nonce) for the default labeling
+func GetIPoWDigest(h hashing.HashFunc, lb Labeling, i uint64, nonce *big.Int) []byte {
+	return lb.Digest(h, i, nonce)
+}
+
+// Returns true iff nonce is a valid iPoW for table entry i labeled using lb with difficulty l. e.g. digest < p
+func IsValidIPoW(h hashing.HashFunc, lb Labeling, i uint64, nonce *big.Int, l uint) bool {
+	d := new(big.Int).SetBytes(GetIPoWDigest(h, lb, i, nonce))
+	return d.Cmp(util.GetMask(uint(h.Size()), l)) <= 0
+}
+
+// Returns the max permitted iPoW nonce value ceil(k/p) for difficulty l
+func GetMaxNonce(l uint) *big.Int {
+	// K / p where p = 2^-l. It doesn't fit in an int64 for l >= 55
+	return new(big.Int).Lsh(big.NewInt(int64(K)), l)
+}
+
+// Write an entry to the store, add it to the merkle tree and checkpoint the store every checkpointInterval entries
+// entries - # of entries in the store after writing data
+func (t *Table) writeEntry(data uint64, entries uint64) error {
+	err := t.s.Write(data, byte(t.p.L))
+	if err != nil {
+		return err
+	}
+	t.written = entries
+
+	if t.acc != nil {
+		err = t.acc.Add(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.progress != nil && entries%progressInterval == 0 {
+		t.progress(t.meter.progress(entries))
+	}
+
+	if entries%checkpointInterval != 0 {
+		return nil
+	}
+	t.cp.Entries = entries
+	return t.saveCheckpoint()
+}
+
+// Close the store of an interrupted generation and save the checkpoint of its first entries entries
+// Returns err unless closing the store or saving the checkpoint failed
+func (t *Table) stop(entries uint64, err error) error {
+	cerr := t.s.Close()
+	if cerr != nil {
+		return cerr
+	}
+
+	t.cp.Entries = entries
+	cerr = t.saveCheckpoint()
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
 }
 
 func (t *Table) finalize() error {
-	return t.s.Close()
+	err := t.s.Close()
+	if err != nil {
+		return err
+	}
+
+	t.cp.Entries = uint64(math.Pow(2, float64(t.p.N)))
+	if t.progress != nil && t.cp.Entries%progressInterval != 0 {
+		// the last entries were not reported
+		t.progress(t.meter.progress(t.cp.Entries))
+	}
+
+	return t.saveCheckpoint()
 }
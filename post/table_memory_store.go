@@ -1,25 +1,89 @@
 package post
 
 import (
+	"errors"
 	"github.com/Workiva/go-datastructures/bitarray"
 	"github.com/avive/rpost/util"
 )
 
-// a simple in-ram post data store implementing StoreReader
+// Name returned by FileName() of in-memory stores
+const memoryStoreName = ":memory:"
+
+// An in-ram post data store implementing StoreWriter and StoreReader
+// Entries are bit-packed with the same encoding as the data of a store file
 type MemoryStore struct {
-	data []uint64
+	data []byte
+	n    uint   // number of bits stored per entry
+	bits uint64 // # of bits written
 }
 
-func (ms *MemoryStore) ReadUint64(idx uint64) (uint64, error) {
-	return ms.data[idx], nil
+// Create an empty in-memory store of l bits entries
+func NewMemoryStore(l uint) *MemoryStore {
+	return &MemoryStore{n: l}
 }
 
+// Returns an in-memory store reader of 64 bits entries with values data
 func NewMemoryStoreReader(data []uint64) StoreReader {
-	return &MemoryStore{data}
+	ms := &MemoryStore{data: make([]byte, 0, len(data)*8), n: 64}
+	for _, v := range data {
+		_ = ms.Write(v, 64)
+	}
+	return ms
+}
+
+// Append the n lsb bits of r msb first
+func (ms *MemoryStore) Write(r uint64, n byte) error {
+	for i := int(n) - 1; i >= 0; i-- {
+		if ms.bits%8 == 0 {
+			ms.data = append(ms.data, 0)
+		}
+		if r>>uint(i)&1 == 1 {
+			ms.data[ms.bits/8] |= 1 << (7 - ms.bits%8)
+		}
+		ms.bits++
+	}
+	return nil
+}
+
+func (ms *MemoryStore) WriteBool(b bool) error {
+	var r uint64
+	if b {
+		r = 1
+	}
+	return ms.Write(r, 1)
+}
+
+// Returns the store data. Equals to the content of a store file with the same entries after its header
+func (ms *MemoryStore) Bytes() []byte {
+	return ms.data
+}
+
+func (ms *MemoryStore) ReadUint64(idx uint64) (uint64, error) {
+	offsetBits := idx * uint64(ms.n)
+	if offsetBits+uint64(ms.n) > ms.bits {
+		return 0, errors.New("data for idx not found")
+	}
+	return decodeEntry(ms.data[offsetBits/8:], uint(offsetBits%8), ms.n), nil
+}
+
+func (ms *MemoryStore) ReadUint64Batch(indices []uint64) ([]uint64, error) {
+	res := make([]uint64, len(indices))
+	for i, idx := range indices {
+		v, err := ms.ReadUint64(idx)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
 }
 
 func (ms *MemoryStore) Read(idx uint64) (bitarray.BitArray, error) {
-	panic("not yet implemented")
+	v, err := ms.ReadUint64(idx)
+	if err != nil {
+		return bitarray.NewBitArray(uint64(ms.n), false), err
+	}
+	return entryBitArray(v, ms.n)
 }
 
 func (ms *MemoryStore) Close() error {
@@ -27,7 +91,7 @@ func (ms *MemoryStore) Close() error {
 }
 
 func (ms *MemoryStore) FileName() string {
-	return "" // hack alert
+	return memoryStoreName
 }
 
 // read from index idx and return as []byte
@@ -40,3 +104,17 @@ func (ms *MemoryStore) ReadBytes(idx uint64) ([]byte, error) {
 	return util.EncodeToBytes(v), nil
 
 }
+
+// Returns a bit array of the n bits entry v. Bit i of the result is the i-th entry bit msb first
+func entryBitArray(v uint64, n uint) (bitarray.BitArray, error) {
+	res := bitarray.NewBitArray(uint64(n), false)
+	for i := uint64(0); i < uint64(n); i++ {
+		if v>>(uint64(n)-1-i)&1 == 1 {
+			err := res.SetBit(i)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
@@ -1,7 +1,9 @@
 package post
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/Workiva/go-datastructures/bitarray"
 	"github.com/avive/rpost/util"
 	"github.com/icza/bitio"
@@ -21,48 +23,195 @@ type StoreReader interface {
 	Read(idx uint64) (bitarray.BitArray, error)
 	ReadUint64(idx uint64) (uint64, error)
 	ReadBytes(idx uint64) ([]byte, error)
+	ReadUint64Batch(indices []uint64) ([]uint64, error) // read the entries at indices. Results are in indices order
 	Close() error
 	FileName() string
 }
 
 type store struct {
-	filePath string   // disk store data file path + name
-	file     *os.File // file
-	writer   bitio.Writer
-	n        uint   // number of bits stored per entry
-	sz       uint64 // file size in bytes - only used when reading
+	backend Backend
+	writer  bitio.Writer
+	n       uint   // number of bits stored per entry
+	sz      uint64 // file size in bytes - only used when reading
 }
 
-func NewStoreWriter(filePath string, n uint) (StoreWriter, error) {
+// Create a new store file for the table described by hdr. Any existing file is truncated
+func NewStoreWriter(filePath string, hdr *Header) (StoreWriter, error) {
+	b, err := openFileBackend(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
 
-	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	res, err := NewStoreWriterWithBackend(b, hdr)
 	if err != nil {
+		b.Close()
 		return nil, err
 	}
+	return res, nil
+}
 
-	return &store{filePath,
-		f,
-		bitio.NewWriter(f),
-		n, 0}, nil
+// Create a new store for the table described by hdr in b. Any existing data is discarded
+func NewStoreWriterWithBackend(b Backend, hdr *Header) (StoreWriter, error) {
+	return OpenStoreWriterWithBackend(b, hdr, 0)
 }
 
-func NewStoreReader(filePath string, n uint) (StoreReader, error) {
+// Open an existing store for appending entries after its first entries entries
+// The store is truncated to exactly entries entries. entries*l must be a multiple of 8 so appending starts on
+// a byte boundary. Returns an error if the store file wasn't created for the table described by hdr
+func OpenStoreWriter(filePath string, hdr *Header, entries uint64) (StoreWriter, error) {
+
+	if entries*uint64(hdr.L)%8 != 0 {
+		return nil, errors.New("store can only be resumed from a byte aligned entry")
+	}
 
-	f, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	b, err := openFileBackend(filePath, os.O_RDWR|os.O_CREATE)
 	if err != nil {
 		return nil, err
 	}
 
-	fi, err := f.Stat()
+	res, err := OpenStoreWriterWithBackend(b, hdr, entries)
 	if err != nil {
+		b.Close()
 		return nil, err
 	}
+	return res, nil
+}
 
-	return &store{filePath,
-		f,
+// Open an existing store in b for appending entries after its first entries entries
+// b is left open on error
+func OpenStoreWriterWithBackend(b Backend, hdr *Header, entries uint64) (StoreWriter, error) {
+
+	if entries*uint64(hdr.L)%8 != 0 {
+		return nil, errors.New("store can only be resumed from a byte aligned entry")
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 || entries == 0 {
+		// nothing to keep - start a new store
+		err = b.Truncate(0)
+		if err == nil {
+			_, err = b.WriteAt(hdr.withMagic(storeMagic).Bytes(), 0)
+		}
+	} else {
+		var h *Header
+		h, err = readHeader(b, storeMagic)
+		if err == nil && (h.IdHash != hdr.IdHash || h.N != hdr.N || h.L != hdr.L || h.HashId != hdr.HashId || h.Labeling != hdr.Labeling) {
+			err = errors.New("store file was created for a different table")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size = int64(HeaderSize + entries*uint64(hdr.L)/8)
+
+	err = b.Truncate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{b,
+		bitio.NewWriter(&offsetWriter{b, size}),
+		hdr.L, 0}, nil
+}
+
+// Open the post store of the table with params p for reading
+// Returns an error if the store file has no valid header or wasn't created for the table
+func NewStoreReader(filePath string, p *Params, opts ...ReaderOption) (StoreReader, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := openFileBackend(filePath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NewStoreReaderWithBackend(b, p, opts...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Open a store file for reading l bits entries. e.g. a shard of a table or a file of an unknown table
+// Returns an error if the store file has no valid header or if its entries are not l bits long
+func newStoreReader(filePath string, l uint, opts ...ReaderOption) (StoreReader, error) {
+	b, err := openFileBackend(filePath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := newStoreReaderWithBackend(b, l, opts...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Open the post store of the table with params p in b for reading. Memory mapping is only supported for file backends
+// b is left open on error
+func NewStoreReaderWithBackend(b Backend, p *Params, opts ...ReaderOption) (StoreReader, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return readStore(b, func(h *Header) error {
+		return h.Validate(p.Id, p.N, p.L, p.HashId)
+	}, opts...)
+}
+
+// Open a store in b for reading l bits entries. b is left open on error
+func newStoreReaderWithBackend(b Backend, l uint, opts ...ReaderOption) (StoreReader, error) {
+	if l < 1 || l > 63 {
+		return nil, errParamsL
+	}
+
+	return readStore(b, func(h *Header) error {
+		if h.L != l {
+			return fmt.Errorf("store has %d bits per entry. Expected %d", h.L, l)
+		}
+		return nil
+	}, opts...)
+}
+
+// Open the store in b for reading once validate accepts its header. Entries are read with the header bits width
+func readStore(b Backend, validate func(h *Header) error, opts ...ReaderOption) (StoreReader, error) {
+
+	h, err := readHeader(b, storeMagic)
+	if err == nil {
+		err = validate(h)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	n := h.L
+
+	if newReaderOptions(opts).mmap {
+		f, err := backendFile(b)
+		if err != nil {
+			return nil, err
+		}
+		return newMmapStore(f.Name(), f, n)
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{b,
 		nil,
 		n,
-		uint64(fi.Size())}, nil
+		uint64(size)}, nil
 }
 
 func (s *store) Write(r uint64, n byte) error {
@@ -74,28 +223,90 @@ func (s *store) WriteBool(b bool) error {
 }
 
 func (s *store) Close() error {
-	return s.writer.Close()
+	if s.writer != nil {
+		// flush any cached bits
+		err := s.writer.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return s.backend.Close()
 }
 
 func (s *store) FileName() string {
-	return s.filePath
+	return s.backend.Name()
 }
 
 // Read from index id and return decoded uint64
 func (s *store) ReadUint64(idx uint64) (uint64, error) {
-	v, err := s.Read(idx)
+
+	offsetBits := idx * uint64(s.n)
+
+	// an entry of up to 63 bits spans up to 9 bytes
+	var buff [9]byte
+	l := (offsetBits%8 + uint64(s.n) + 7) / 8
+
+	n, err := s.backend.ReadAt(buff[:l], int64(HeaderSize+offsetBits/8))
 	if err != nil {
 		return 0, err
 	}
+	if n == 0 {
+		return 0, errors.New("data for idx not found")
+	}
 
-	res, err := util.Uint64Value(v, uint64(s.n))
+	return decodeEntry(buff[:l], uint(offsetBits%8), s.n), nil
+}
+
+// Read the entries at indices with a batch of sorted, coalesced and concurrent reads
+func (s *store) ReadUint64Batch(indices []uint64) ([]uint64, error) {
+
+	reqs := make([]readRequest, len(indices))
+	for i, idx := range indices {
+		offsetBits := idx * uint64(s.n)
+		reqs[i] = readRequest{int64(HeaderSize + offsetBits/8), int((offsetBits%8 + uint64(s.n) + 7) / 8)}
+	}
+
+	data, err := readBatch(s.backend, reqs)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	res := make([]uint64, len(indices))
+	for i, idx := range indices {
+		res[i] = decodeEntry(data[i], uint(idx*uint64(s.n)%8), s.n)
 	}
 
 	return res, nil
 }
 
+// Returns the n bits entry starting at bit offset o < 8 of buff. Bits are read msb first
+// The entry is extracted from a 64 bits big-endian window of buff, extended by a 9th byte when the entry spans it
+func decodeEntry(buff []byte, o uint, n uint) uint64 {
+
+	// # of bytes spanned by the entry
+	l := (o + n + 7) / 8
+
+	var w uint64
+	if len(buff) >= 8 {
+		w = binary.BigEndian.Uint64(buff)
+	} else {
+		for i := 0; i < 8; i++ {
+			w <<= 8
+			if i < len(buff) {
+				w |= uint64(buff[i])
+			}
+		}
+	}
+
+	w <<= o
+	if l > 8 {
+		// o > 0 since n < 64
+		w |= uint64(buff[8]) >> (8 - o)
+	}
+
+	return w >> (64 - n)
+}
+
 // read from index idx and return as []byte
 func (s *store) ReadBytes(idx uint64) ([]byte, error) {
 	v, err := s.ReadUint64(idx)
@@ -116,29 +327,21 @@ func (s *store) Read(idx uint64) (bitarray.BitArray, error) {
 	// fmt.Printf("Reading %d bits entry from store at index %d...\n", s.n, idx)
 
 	// First, figure out how many bytes we need to read and in which offset
-	l := s.n / 8
-	if s.n%8 != 0 {
-		// we need to grow the buffer to accommodate up to 7 extra bits of data
-		l += 1
-	}
-
 	offsetBits := idx * uint64(s.n)
 	// fmt.Printf("Bits offset: %d\n", offsetBits)
 
 	offsetBytes := offsetBits / 8
 	// fmt.Printf("Bytes offset: %d\n", offsetBytes)
 
-	if offsetBits%8 != 0 && offsetBytes+uint64(l)+1 < s.sz {
-		// we are starting to read before the first data bit so we
-		// need to grow the buffer by one unless this there are no more bytes in the file
-		l += 1
-	}
+	// we may start to read before the first data bit so we need to read the bytes spanning
+	// offsetBits % 8 + s.n bits
+	l := (offsetBits%8 + uint64(s.n) + 7) / 8
 
 	// Read data goes here
 	res := bitarray.NewBitArray(uint64(s.n), false)
 
 	buff := make([]byte, l)
-	n, err := s.file.ReadAt(buff, int64(offsetBytes))
+	n, err := s.backend.ReadAt(buff, int64(HeaderSize+offsetBytes))
 	if err != nil {
 		return res, err
 	}
@@ -0,0 +1,167 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ReadUint64 word-level extraction must decode the same entries as the per-bit Read for any l
+func TestReadUint64(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, "post_bits.bin")
+
+	const entries = 1000
+
+	for l := uint(1); l <= 63; l++ {
+
+		hdr := NewHeader(util.Rnd(t, 32), 10, l, hashing.SHA256)
+		sw, err := NewStoreWriter(f, hdr)
+		assert.NoError(t, err)
+
+		data := make([]uint64, entries)
+		for i := range data {
+			data[i] = rand.Uint64() & (1<<l - 1)
+			assert.NoError(t, sw.Write(data[i], byte(l)))
+		}
+		assert.NoError(t, sw.Close())
+
+		for _, opts := range [][]ReaderOption{nil, {WithMmap()}} {
+			sr, err := newStoreReader(f, l, opts...)
+			assert.NoError(t, err)
+
+			// first, last and arbitrary entries
+			indices := []uint64{0, entries - 1}
+			for i := 0; i < 200; i++ {
+				indices = append(indices, uint64(rand.Intn(entries)))
+			}
+
+			for _, idx := range indices {
+				v, err := sr.ReadUint64(idx)
+				assert.NoError(t, err)
+				assert.Equal(t, data[idx], v, "l: %d. entry: %d", l, idx)
+
+				bits, err := sr.Read(idx)
+				assert.NoError(t, err)
+				v1, err := util.Uint64Value(bits, uint64(l))
+				assert.NoError(t, err)
+				assert.Equal(t, v1, v, "l: %d. entry: %d", l, idx)
+			}
+
+			assert.NoError(t, sr.Close())
+		}
+	}
+}
+
+// decodeEntry must decode any bit offset and length from any buffer
+func TestDecodeEntry(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		n := uint(1 + rand.Intn(63))
+		o := uint(rand.Intn(8))
+		buff := make([]byte, (o+n+7)/8+uint(rand.Intn(3)))
+		rand.Read(buff)
+
+		var expected uint64
+		for b := o; b < o+n; b++ {
+			if util.GetNthBit(buff[b/8], uint64(7-b%8)) {
+				expected |= 1 << (o + n - 1 - b)
+			}
+		}
+
+		assert.Equal(t, expected, decodeEntry(buff, o, n), "offset: %d. bits: %d", o, n)
+	}
+}
+
+// decodeEntry must decode the same entries as the per-bit store Read
+// Run with go test -fuzz FuzzDecodeEntry ./post
+func FuzzDecodeEntry(f *testing.F) {
+	f.Add([]byte{0xa5, 0x5a, 0xff, 0x00, 0x12, 0x34, 0x56, 0x78, 0x9a}, uint8(7), uint64(3))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint8(63), uint64(1))
+	f.Add([]byte{0x80}, uint8(1), uint64(0))
+
+	hdr := NewHeader([]byte("fuzz decode entry"), 10, 1, hashing.SHA256).withMagic(storeMagic)
+
+	f.Fuzz(func(t *testing.T, data []byte, l uint8, idx uint64) {
+		n := uint(l%63) + 1
+		entries := uint64(len(data)) * 8 / uint64(n)
+		if entries == 0 {
+			return
+		}
+		idx %= entries
+
+		h := *hdr
+		h.L = n
+		b := &memBackend{data: append(h.Bytes(), data...)}
+		sr, err := newStoreReaderWithBackend(b, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bits, err := sr.Read(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := util.Uint64Value(bits, uint64(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := idx * uint64(n)
+		assert.Equal(t, expected, decodeEntry(data[o/8:], uint(o%8), n), "offset: %d. bits: %d", o%8, n)
+
+		v, err := sr.ReadUint64(idx)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, v, "entry: %d. bits: %d", idx, n)
+	})
+}
+
+// A memory store has the same encoding as a store file
+func TestMemoryStore(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, "post_memory.bin")
+
+	const entries = 333
+
+	for _, l := range []uint{1, 5, 8, 13, 63} {
+
+		sw, err := NewStoreWriter(f, NewHeader(util.Rnd(t, 32), 10, l, hashing.SHA256))
+		assert.NoError(t, err)
+		ms := NewMemoryStore(l)
+
+		data := make([]uint64, entries)
+		for i := range data {
+			data[i] = rand.Uint64() & (1<<l - 1)
+			assert.NoError(t, sw.Write(data[i], byte(l)))
+			assert.NoError(t, ms.Write(data[i], byte(l)))
+		}
+		assert.NoError(t, sw.Close())
+
+		fileData, err := ioutil.ReadFile(f)
+		assert.NoError(t, err)
+		assert.Equal(t, fileData[HeaderSize:], ms.Bytes(), "l: %d", l)
+
+		for i, v := range data {
+			v1, err := ms.ReadUint64(uint64(i))
+			assert.NoError(t, err)
+			assert.Equal(t, v, v1, "l: %d. entry: %d", l, i)
+		}
+
+		_, err = ms.ReadUint64(entries)
+		assert.Error(t, err)
+	}
+}
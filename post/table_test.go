@@ -1,18 +1,23 @@
 package post
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/avive/rpost/hashing"
 	"github.com/avive/rpost/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestTable(t *testing.T) {
-	testTable(t, 4, 20, "post.bin")
+	testTable(t, 9, 8, "post.bin")
 }
 
 // n - Table size T = 2^n
@@ -48,7 +53,7 @@ func testTable(t *testing.T, n uint64, l uint, fileName string) {
 
 	// test reading stored data from disc vs. expected data
 	// returned in ram from Store()
-	storeReader, err := NewStoreReader(f, l)
+	storeReader, err := newStoreReader(f, l)
 	assert.NoError(t, err)
 
 	tableSize := uint64(math.Pow(2, float64(n)))
@@ -76,6 +81,490 @@ func validateStoreSize(t *testing.T, filePath string, tableSize uint64, bitsPerE
 	defer file.Close()
 	fileInfo, err := file.Stat()
 	assert.NoError(t, err)
-	expectedFileSize := tableSize*bitsPerEntry/8 + (tableSize % 8)
+	expectedFileSize := HeaderSize + tableSize*bitsPerEntry/8 + (tableSize % 8)
 	assert.Equal(t, expectedFileSize, uint64(fileInfo.Size()))
 }
+
+func TestParallelTable(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(12), uint(8)
+	f := filepath.Join(currFolder, "post_serial.bin")
+	f1 := filepath.Join(currFolder, "post_parallel.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	table, err := NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	res, err := table.Generate(true)
+	assert.NoError(t, err)
+
+	table, err = NewTable(id, n, l, h, f1)
+	assert.NoError(t, err)
+	table.SetWorkers(4)
+	res1, err := table.Generate(true)
+	assert.NoError(t, err)
+
+	assert.Equal(t, res, res1)
+
+	data, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	data1, err := ioutil.ReadFile(f1)
+	assert.NoError(t, err)
+	assert.Equal(t, data, data1, "expected same store for serial and parallel generation")
+}
+
+func TestResumeTable(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(10), uint(6)
+	tableSize := uint64(math.Pow(2, float64(n)))
+
+	f := filepath.Join(currFolder, "post_full.bin")
+	mf := filepath.Join(currFolder, "merkle_full.bin")
+	f1 := filepath.Join(currFolder, "post_resumed.bin")
+	mf1 := filepath.Join(currFolder, "merkle_resumed.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	table, err := NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	comm, err := table.Store(mf)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	mData, err := ioutil.ReadFile(mf)
+	assert.NoError(t, err)
+
+	// resume a partially written store - the checkpoint is ahead of the store file
+	err = ioutil.WriteFile(f1, data[:HeaderSize+301], 0666)
+	assert.NoError(t, err)
+	writeCheckpointFile(t, f1, &checkpoint{Id: id, N: n, L: l, Hash: h.Id(), Entries: 500})
+
+	table, err = OpenTable(id, n, l, h, f1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(400), table.start, "expected to resume from last byte aligned entry")
+	comm1, err := table.Store(mf1)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+	assertSameFile(t, f, f1)
+	assertSameFile(t, mf, mf1)
+
+	// resume a partially written merkle tree - the merkle tree file has a partial label
+	err = ioutil.WriteFile(mf1, mData[:HeaderSize+500*32+7], 0666)
+	assert.NoError(t, err)
+	writeCheckpointFile(t, f1, &checkpoint{Id: id, N: n, L: l, Hash: h.Id(), Entries: tableSize, MerkleLabels: 600})
+
+	table, err = OpenTable(id, n, l, h, f1)
+	assert.NoError(t, err)
+	comm1, err = table.Store(mf1)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+	assertSameFile(t, f, f1)
+	assertSameFile(t, mf, mf1)
+
+	// resume a partially written store with merkle labels of entries which are not in the store
+	err = ioutil.WriteFile(f1, data[:HeaderSize+300], 0666)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(mf1, mData[:HeaderSize+600*32], 0666)
+	assert.NoError(t, err)
+	writeCheckpointFile(t, f1, &checkpoint{Id: id, N: n, L: l, Hash: h.Id(), Entries: 400, MerkleLabels: 600})
+
+	table, err = OpenTable(id, n, l, h, f1)
+	assert.NoError(t, err)
+	comm1, err = table.Store(mf1)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+	assertSameFile(t, f, f1)
+	assertSameFile(t, mf, mf1)
+
+	// a fully initialized table is not regenerated
+	table, err = OpenTable(id, n, l, h, f1)
+	assert.NoError(t, err)
+	assert.Equal(t, tableSize, table.start)
+	comm1, err = table.Store(mf1)
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+
+	// refuse to resume with different params
+	_, err = OpenTable(id, n, l+1, h, f1)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+	_, err = OpenTable(id, n+1, l, h, f1)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+	_, err = OpenTable(util.Rnd(t, 32), n, l, h, f1)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+	h1, err := hashing.NewHashFuncWithBackend(id, hashing.BLAKE2b256)
+	assert.NoError(t, err)
+	_, err = OpenTable(id, n, l, h1, f1)
+	assert.Equal(t, ErrCheckpointMismatch, err)
+}
+
+func TestHashBackends(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(4)
+
+	f := filepath.Join(currFolder, "post_backend.bin")
+	mf := filepath.Join(currFolder, "merkle_backend.bin")
+
+	id := []byte("rpost hash backends test id")
+
+	comms := make(map[byte][]byte)
+
+	for _, b := range hashing.Backends() {
+
+		// same backend always gives the same commitment
+		var comm []byte
+		for i := 0; i < 2; i++ {
+			h, err := hashing.NewHashFuncWithBackend(id, b.Id)
+			assert.NoError(t, err)
+
+			table, err := NewTable(id, n, l, h, f)
+			assert.NoError(t, err)
+			c, err := table.Store(mf)
+			assert.NoError(t, err)
+			assert.Len(t, c, WB)
+
+			if comm != nil {
+				assert.Equal(t, comm, c, "expected a deterministic commitment for %s", b.Name)
+			}
+			comm = c
+		}
+
+		hdr, err := ReadMerkleHeader(mf)
+		assert.NoError(t, err)
+		assert.Equal(t, b.Id, hdr.HashId)
+
+		comms[b.Id] = comm
+	}
+
+	assert.Equal(t, comms[hashing.SHA256], comms[hashing.SHA256Go], "expected sha256 implementations to agree")
+	assert.NotEqual(t, comms[hashing.SHA256], comms[hashing.SHA512_256])
+	assert.NotEqual(t, comms[hashing.SHA256], comms[hashing.BLAKE2b256])
+	assert.NotEqual(t, comms[hashing.SHA512_256], comms[hashing.BLAKE2b256])
+}
+
+func assertSameFile(t *testing.T, fileName string, fileName1 string) {
+	data, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	data1, err := ioutil.ReadFile(fileName1)
+	assert.NoError(t, err)
+	assert.Equal(t, data, data1, "expected %s and %s to have the same content", fileName, fileName1)
+}
+
+func TestIncrementalMerkle(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(10), uint(6)
+	f := filepath.Join(currFolder, "post_incremental.bin")
+	mf := filepath.Join(currFolder, "merkle_incremental.bin")
+	mf1 := filepath.Join(currFolder, "merkle_from_store.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	for _, workers := range []uint{1, 4} {
+
+		// merkle tree is built while the table is generated
+		table, err := NewTable(id, n, l, h, f)
+		assert.NoError(t, err)
+		table.SetWorkers(workers)
+		assert.NoError(t, table.SetOmittedLevels(workers-1))
+		comm, err := table.Store(mf)
+		assert.NoError(t, err)
+
+		// merkle tree written from the generated store
+		sr, err := newStoreReader(f, l)
+		assert.NoError(t, err)
+		hdr := table.header()
+		hdr.OmittedLevels = workers - 1
+		mw, err := NewMerkleTreeWriter(sr, mf1, hdr, h)
+		assert.NoError(t, err)
+		comm1, err := mw.Write()
+		assert.NoError(t, err)
+		err = sr.Close()
+		assert.NoError(t, err)
+
+		assert.Equal(t, comm1, comm)
+		assertSameFile(t, mf1, mf)
+	}
+}
+
+// A table generated in memory has the same commitment and merkle tree as a table generated to files
+func TestMemoryTable(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(5)
+	f := filepath.Join(currFolder, "post_file.bin")
+	mf := filepath.Join(currFolder, "merkle_file.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	table, err := NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	assert.NoError(t, table.SetOmittedLevels(2))
+	comm, err := table.Store(mf)
+	assert.NoError(t, err)
+
+	ms := NewMemoryStore(l)
+	table, err = NewMemoryTable(id, n, l, h, Labeling{}, ms)
+	assert.NoError(t, err)
+	assert.NoError(t, table.SetOmittedLevels(2))
+	comm1, ts, err := table.StoreInMemory()
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+
+	data, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, data[HeaderSize:], ms.Bytes())
+
+	var ids []Identifier
+	postOrderIds(uint(n-1), 2, rootId, &ids)
+
+	r, err := NewTreeStoreReader(mf, uint(n-1))
+	assert.NoError(t, err)
+	labels, err := r.ReadBatch(ids)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	labels1, err := ts.ReadBatch(ids)
+	assert.NoError(t, err)
+	assert.Equal(t, labels, labels1)
+}
+
+func TestShardedTable(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(5)
+	dirs := []string{filepath.Join(currFolder, "shards-a"), filepath.Join(currFolder, "shards-b")}
+	for _, dir := range dirs {
+		assert.NoError(t, os.MkdirAll(dir, 0777))
+		defer os.RemoveAll(dir)
+	}
+	mf := filepath.Join(dirs[0], "manifest.json")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	ms := NewMemoryStore(l)
+	table, err := NewMemoryTable(id, n, l, h, Labeling{}, ms)
+	assert.NoError(t, err)
+	assert.NoError(t, table.SetOmittedLevels(2))
+	comm, ts, err := table.StoreInMemory()
+	assert.NoError(t, err)
+
+	for _, shardBits := range []uint{0, 2} {
+		m, err := NewManifest(n, l, shardBits, dirs)
+		assert.NoError(t, err)
+
+		table, err = NewShardedTable(id, n, l, h, Labeling{}, m, mf)
+		assert.NoError(t, err)
+		assert.NoError(t, table.SetOmittedLevels(2))
+		table.SetMerkleLayout(LayoutLevelOrder)
+		comm1, err := table.StoreSharded()
+		assert.NoError(t, err)
+		assert.Equal(t, comm, comm1)
+
+		m, err = ReadManifest(mf)
+		assert.NoError(t, err)
+		assert.Equal(t, comm, m.Commitment)
+		assert.NoError(t, m.ValidateShards(id, h.Id()))
+		m1 := *m
+		m1.Commitment = comm[:16]
+		assert.Error(t, m1.Validate())
+
+		sr, err := NewShardedStoreReader(mf, table.Params())
+		assert.NoError(t, err)
+		indices := []uint64{511, 0, 128, 127, 300}
+		values, err := sr.ReadUint64Batch(indices)
+		assert.NoError(t, err)
+		for i, idx := range indices {
+			v, err := ms.ReadUint64(idx)
+			assert.NoError(t, err)
+			assert.Equal(t, v, values[i])
+		}
+		assert.NoError(t, sr.Close())
+
+		var ids []Identifier
+		postOrderIds(uint(n-1), 2, rootId, &ids)
+
+		r, err := NewShardedTreeStoreReader(mf)
+		assert.NoError(t, err)
+		labels, err := r.ReadBatch(ids)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+
+		labels1, err := ts.ReadBatch(ids)
+		assert.NoError(t, err)
+		assert.Equal(t, labels1, labels, "shard bits: %d", shardBits)
+	}
+}
+
+func TestTableProgress(t *testing.T) {
+
+	const n, l = uint64(11), uint(4)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	for _, workers := range []uint{1, 4} {
+		table, err := NewMemoryTable(id, n, l, h, Labeling{}, NewMemoryStore(l))
+		assert.NoError(t, err)
+		table.SetWorkers(workers)
+
+		var reports []Progress
+		table.SetProgress(func(p Progress) {
+			reports = append(reports, p)
+		})
+
+		out := &bytes.Buffer{}
+		table.SetLogger(log.New(out, "", 0))
+
+		_, err = table.Generate(false)
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "Table size: 2048")
+
+		assert.Len(t, reports, 2)
+		assert.Equal(t, uint64(1024), reports[0].Entries)
+		last := reports[len(reports)-1]
+		assert.Equal(t, uint64(2048), last.Entries)
+		assert.Equal(t, uint64(2048), last.Total)
+		assert.True(t, last.HashRate > 0)
+		assert.Equal(t, time.Duration(0), last.ETA)
+		assert.Equal(t, uint64(0), last.Attempts)
+	}
+}
+
+// An interrupted initialization must be resumable to the same table
+func TestCancelTable(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(11), uint(4)
+	f := filepath.Join(currFolder, "post_cancel.bin")
+	mf := filepath.Join(currFolder, "merkle_cancel.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	table, err := NewMemoryTable(id, n, l, h, Labeling{}, NewMemoryStore(l))
+	assert.NoError(t, err)
+	comm, _, err := table.StoreInMemory()
+	assert.NoError(t, err)
+
+	for _, workers := range []uint{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		table, err = NewTable(id, n, l, h, f)
+		assert.NoError(t, err)
+		table.SetWorkers(workers)
+		table.SetProgress(func(p Progress) {
+			cancel()
+		})
+
+		_, err = table.StoreContext(ctx, mf)
+		assert.Equal(t, context.Canceled, err)
+
+		cp := readCheckpointFile(t, f)
+		assert.True(t, cp.Entries >= 1024 && cp.Entries < 1<<n, "entries: %d", cp.Entries)
+		assert.True(t, cp.MerkleLabels > 0)
+
+		table, err = OpenTable(id, n, l, h, f)
+		assert.NoError(t, err)
+		assert.Equal(t, cp.Entries, table.start)
+		comm1, err := table.Store(mf)
+		assert.NoError(t, err)
+		assert.Equal(t, comm, comm1, "workers: %d", workers)
+	}
+
+	// a sharded table resumes from the entries written to its shards
+	dir := filepath.Join(currFolder, "shards-cancel")
+	assert.NoError(t, os.MkdirAll(dir, 0777))
+	defer os.RemoveAll(dir)
+	msf := filepath.Join(dir, "manifest.json")
+
+	m, err := NewManifest(n, l, 2, []string{dir})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	table, err = OpenShardedTable(id, n, l, h, Labeling{}, m, msf)
+	assert.NoError(t, err)
+	table.SetProgress(func(p Progress) {
+		cancel()
+	})
+	_, err = table.StoreShardedContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	cp := readCheckpointFile(t, msf)
+	assert.True(t, cp.Entries >= 1024 && cp.Entries < 1<<n, "entries: %d", cp.Entries)
+
+	table, err = OpenShardedTable(id, n, l, h, Labeling{}, m, msf)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Entries, table.start)
+	comm1, err := table.StoreShardedContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+
+	// a fully initialized table is not regenerated
+	table, err = OpenShardedTable(id, n, l, h, Labeling{}, m, msf)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1)<<n, table.start)
+	comm1, err = table.StoreSharded()
+	assert.NoError(t, err)
+	assert.Equal(t, comm, comm1)
+
+	// shards of another manifest can't be resumed
+	m, err = NewManifest(n, l, 1, []string{dir})
+	assert.NoError(t, err)
+	_, err = OpenShardedTable(id, n, l, h, Labeling{}, m, msf)
+	assert.Error(t, err)
+}
+
+// Write the checkpoint of the store file f
+func writeCheckpointFile(t *testing.T, f string, c *checkpoint) {
+	b, err := openCheckpointBackend(f)
+	assert.NoError(t, err)
+	assert.NoError(t, writeCheckpoint(b, c))
+	assert.NoError(t, b.Close())
+}
+
+// Read the checkpoint of the store file f
+func readCheckpointFile(t *testing.T, f string) *checkpoint {
+	b, err := openCheckpointBackend(f)
+	assert.NoError(t, err)
+	c, err := readCheckpoint(b)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+	return c
+}
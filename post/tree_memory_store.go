@@ -0,0 +1,109 @@
+package post
+
+import (
+	"errors"
+)
+
+// An in-ram tree store implementing TreeStoreWriter and TreeStoreReader
+// Labels may be written in any order. Labels of the omitted levels of the tree are not stored
+type MemoryTreeStore struct {
+	n      uint   // tree height
+	k      uint   // # of lowest tree levels which labels are not stored
+	wb     uint64 // label size in bytes
+	labels Labels // level-order labels. nil for labels which weren't written
+	c      uint64 // num of labels written to store
+}
+
+// Create an empty in-memory store for the merkle tree of the table described by hdr
+func NewMemoryTreeStore(hdr *Header) *MemoryTreeStore {
+	res := &MemoryTreeStore{
+		n:  uint(hdr.N - 1),
+		k:  hdr.OmittedLevels,
+		wb: hdr.LabelSize(),
+	}
+	res.labels = make(Labels, uint64(1)<<(res.n-res.k+1)-1)
+	return res
+}
+
+// Returns the level-order index of node id
+func (d *MemoryTreeStore) index(id Identifier) (uint64, error) {
+	depth, pos, err := parseNodeId(id, d.n, d.k)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(1)<<depth - 1 + pos, nil
+}
+
+func (d *MemoryTreeStore) Write(id Identifier, l Label) error {
+	idx, err := d.index(id)
+	if err != nil {
+		return err
+	}
+
+	if d.labels[idx] == nil {
+		d.c += 1
+	}
+	d.labels[idx] = append(Label(nil), l...)
+	return nil
+}
+
+func (d *MemoryTreeStore) IsLabelInStore(id Identifier) (bool, error) {
+	idx, err := d.index(id)
+	if err != nil {
+		return false, err
+	}
+	return d.labels[idx] != nil, nil
+}
+
+// Removes all labels from the store
+func (d *MemoryTreeStore) Reset() error {
+	d.labels = make(Labels, len(d.labels))
+	d.c = 0
+	return nil
+}
+
+func (d *MemoryTreeStore) Delete() error {
+	return d.Reset()
+}
+
+// Returns the size in bytes of the labels in the store
+func (d *MemoryTreeStore) Size() uint64 {
+	return d.c * d.wb
+}
+
+func (d *MemoryTreeStore) Finalize() error {
+	return nil
+}
+
+func (d *MemoryTreeStore) Close() error {
+	return nil
+}
+
+// Returns the label of node id or error if it is not in the store
+func (d *MemoryTreeStore) Read(id Identifier) (Label, error) {
+	idx, err := d.index(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.labels[idx] == nil {
+		return nil, errors.New("label is not in the store")
+	}
+
+	return d.labels[idx], nil
+}
+
+func (d *MemoryTreeStore) ReadBatch(ids []Identifier) (Labels, error) {
+	res := make(Labels, len(ids))
+	for i, id := range ids {
+		l, err := d.Read(id)
+		if err == ErrLabelNotStored {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[i] = l
+	}
+	return res, nil
+}
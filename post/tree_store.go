@@ -2,19 +2,55 @@ package post
 
 import (
 	"errors"
+	"fmt"
 	"github.com/avive/rpost/bstring"
 	"github.com/avive/rpost/util"
+	"io"
 	"math"
 	"os"
 )
 
 // A simple known-size full binary tree (such as a Merkle tree) store with fixed-size labels
-// Labels size is WB
+// Labels size is the output size of the hash backend recorded in the store header
+// Labels are stored in depth-first post-order or in level-order. The layout is recorded in the store header
+// The labels of the lowest levels of the tree may be omitted from the store. The omitted levels are recorded in the
+// store header. Reading the label of a node in an omitted level returns ErrLabelNotStored
 
 const (
 	buffSizeBytes = 1024 * 1024 // Write buffer size
 )
 
+type TreeLayout byte
+
+// Tree store labels layouts
+const (
+	LayoutDepthFirst TreeLayout = 0 // depth-first post-order. Labels are appended as they are computed
+	LayoutLevelOrder TreeLayout = 1 // level-order from the root. O(1) label offsets
+)
+
+func (l TreeLayout) String() string {
+	switch l {
+	case LayoutDepthFirst:
+		return "depth-first"
+	case LayoutLevelOrder:
+		return "level-order"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(l))
+	}
+}
+
+// Returns the layout with the provided name
+func ParseTreeLayout(name string) (TreeLayout, error) {
+	for _, l := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		if l.String() == name {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown merkle tree layout %q", name)
+}
+
+var ErrLabelNotStored = errors.New("label is in an omitted tree level")
+
 type Label []byte      // label is WB bytes long binary data
 type Labels []Label    // an ordered list of Labels
 type Identifier string // A Variable-length binary string. e.g. "0011010" Only 0s and 1s are allowed chars.
@@ -22,69 +58,206 @@ type Identifier string // A Variable-length binary string. e.g. "0011010" Only 0
 // A simple store writer
 // Labels must be written in depth-first order. Random access is not supported
 type TreeStoreWriter interface {
-	Write(id Identifier, l Label)
+	Write(id Identifier, l Label) error
 	IsLabelInStore(id Identifier) (bool, error)
 	Reset() error
 	Delete() error
 	Size() uint64
-	Finalize()    // finalize writing w/o closing the file
-	Close() error // finalize and close
+	Finalize() error // finalize writing w/o closing the file
+	Close() error    // finalize and close
 }
 
 // A simple (k,v) reader - fully supports random access
 type TreeStoreReader interface {
 	Read(id Identifier) (Label, error)
+	ReadBatch(ids []Identifier) (Labels, error) // read the labels of ids in ids order. Labels of omitted levels are nil
 	Size() uint64
 	Close() error
 }
 
 type treeStore struct {
-	fileName string
-	file     *os.File
-	n        uint // 9 <= n < 64
-	f        bstring.BinaryStringFactory
-	bw       *util.Writer
-	c        uint64 // num of labels written to store in this session
+	backend Backend
+	n       uint // 9 <= n < 64
+	f       bstring.BinaryStringFactory
+	bw      *util.Writer
+	c       uint64 // num of labels written to store
+	wb      uint64 // label size in bytes
+	k       uint   // # of lowest tree levels which labels are not stored
 }
 
-// n - binary tree height
-func NewTreeStoreWriter(fileName string, n uint) (TreeStoreWriter, error) {
-	res := &treeStore{
-		fileName: fileName,
-		n:        n,
-		f:        bstring.NewSMBinaryStringFactory(),
+// Create a new store file for the merkle tree of the table described by hdr. Any existing file is truncated
+// Tree height is hdr.N - 1. The store uses the labels layout of hdr
+func NewTreeStoreWriter(fileName string, hdr *Header) (TreeStoreWriter, error) {
+	b, err := openFileBackend(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NewTreeStoreWriterWithBackend(b, hdr)
+	if err != nil {
+		b.Close()
+		return nil, err
 	}
+	return res, nil
+}
+
+// Create a new store for the merkle tree of the table described by hdr in b. Any existing data is discarded
+func NewTreeStoreWriterWithBackend(b Backend, hdr *Header) (TreeStoreWriter, error) {
+	return OpenTreeStoreWriterWithBackend(b, hdr, 0)
+}
 
-	f, err := os.OpenFile(res.fileName, os.O_RDWR|os.O_CREATE, 0666)
+// Open an existing store for appending labels after its first c labels in depth-first post-order
+// A depth-first store is truncated to exactly c labels
+// Returns an error if the store file wasn't created for the table described by hdr
+func OpenTreeStoreWriter(fileName string, hdr *Header, c uint64) (TreeStoreWriter, error) {
+	b, err := openFileBackend(fileName, os.O_RDWR|os.O_CREATE)
 	if err != nil {
 		return nil, err
 	}
-	res.file = f
-	res.bw = util.NewWriterSize(f, buffSizeBytes)
-	return res, err
+
+	res, err := OpenTreeStoreWriterWithBackend(b, hdr, c)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return res, nil
 }
 
-func NewTreeStoreReader(fileName string, n uint) (TreeStoreReader, error) {
+// Open an existing store in b for appending labels after its first c labels in depth-first post-order
+// b is left open on error
+func OpenTreeStoreWriterWithBackend(b Backend, hdr *Header, c uint64) (TreeStoreWriter, error) {
+	if hdr.Layout == LayoutLevelOrder {
+		return openLevelStoreWriter(b, hdr, c)
+	}
+
 	res := &treeStore{
-		fileName: fileName,
-		n:        n,
-		f:        bstring.NewSMBinaryStringFactory(),
+		backend: b,
+		n:       uint(hdr.N - 1),
+		f:       bstring.NewSMBinaryStringFactory(),
+		c:       c,
+		wb:      hdr.LabelSize(),
+		k:       hdr.OmittedLevels,
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
 	}
 
-	f, err := os.OpenFile(res.fileName, os.O_RDONLY, 0666)
+	if size == 0 || c == 0 {
+		// nothing to keep - start a new store
+		err = b.Truncate(0)
+		if err == nil {
+			_, err = b.WriteAt(hdr.withMagic(merkleMagic).Bytes(), 0)
+		}
+	} else {
+		err = checkTreeStoreHeader(b, hdr)
+	}
 	if err != nil {
 		return nil, err
 	}
-	res.file = f
-	return res, err
+
+	size = int64(HeaderSize + c*res.wb)
+
+	err = b.Truncate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	res.bw = util.NewWriterSize(&offsetWriter{b, size}, buffSizeBytes)
+	return res, nil
 }
 
-func (d *treeStore) Write(id Identifier, l Label) {
-	d.c += 1
+// Returns an error if the store header doesn't describe the same table and tree as hdr
+func checkTreeStoreHeader(r io.ReaderAt, hdr *Header) error {
+	h, err := readHeader(r, merkleMagic)
+	if err != nil {
+		return err
+	}
+
+	if h.IdHash != hdr.IdHash || h.N != hdr.N || h.L != hdr.L || h.HashId != hdr.HashId || h.Labeling != hdr.Labeling ||
+		h.OmittedLevels != hdr.OmittedLevels || h.Layout != hdr.Layout {
+		return errors.New("merkle tree file was created for a different table")
+	}
+
+	return nil
+}
+
+// Open a store for reading the labels of a tree of height n
+// Returns an error if the store file has no valid header or if it wasn't created for a tree of height n
+func NewTreeStoreReader(fileName string, n uint, opts ...ReaderOption) (TreeStoreReader, error) {
+	b, err := openFileBackend(fileName, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NewTreeStoreReaderWithBackend(b, n, opts...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Open a store in b for reading the labels of a tree of height n. Memory mapping is only supported for file backends
+// b is left open on error
+func NewTreeStoreReaderWithBackend(b Backend, n uint, opts ...ReaderOption) (TreeStoreReader, error) {
+
+	h, err := readHeader(b, merkleMagic)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	if h.N != uint64(n)+1 {
+		return nil, fmt.Errorf("%s: merkle tree height is %d. Expected %d", b.Name(), h.N-1, n)
+	}
+
+	o := newReaderOptions(opts)
+
+	var f *os.File
+	if o.mmap {
+		f, err = backendFile(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Layout == LayoutLevelOrder {
+		ls := newLevelStoreReader(b, h)
+		if o.mmap {
+			return newMmapTreeStore(ls, f, ls.wb, ls.labelOffset)
+		}
+		return ls, nil
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &treeStore{
+		backend: b,
+		n:       n,
+		f:       bstring.NewSMBinaryStringFactory(),
+		wb:      h.LabelSize(),
+		k:       h.OmittedLevels,
+	}
+	res.c = (uint64(size) - HeaderSize) / res.wb
+
+	if o.mmap {
+		return newMmapTreeStore(res, f, res.wb, res.calcFileIndex)
+	}
+
+	return res, nil
+}
+
+func (d *treeStore) Write(id Identifier, l Label) error {
 	_, err := d.bw.Write(l)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	d.c += 1
+	return nil
 }
 
 // Removes all data from the file
@@ -95,38 +268,39 @@ func (d *treeStore) Reset() error {
 	}
 
 	d.c = 0
-	return d.file.Truncate(0)
+	err = d.backend.Truncate(HeaderSize)
+	if err != nil {
+		return err
+	}
+
+	d.bw = util.NewWriterSize(&offsetWriter{d.backend, HeaderSize}, buffSizeBytes)
+	return nil
 }
 
-func (d *treeStore) Finalize() {
+func (d *treeStore) Finalize() error {
 	// flush buffer to file
 	if d.bw != nil {
-		_ = d.bw.Flush()
+		return d.bw.Flush()
 	}
+	return nil
 }
 
 func (d *treeStore) Close() error {
-	d.Finalize()
-	return d.file.Close()
+	err := d.Finalize()
+	if err != nil {
+		d.backend.Close()
+		return err
+	}
+	return d.backend.Close()
 }
 
 func (d *treeStore) Delete() error {
-	return os.Remove(d.fileName)
+	return removeBackend(d.backend)
 }
 
+// Returns the size in bytes of the labels in the store
 func (d *treeStore) Size() uint64 {
-	stats, err := d.file.Stat()
-	if err != nil {
-		println(err)
-	}
-
-	res := uint64(stats.Size())
-
-	if d.bw != nil {
-		res += uint64(d.bw.Buffered())
-	}
-
-	return res
+	return d.c * d.wb
 }
 
 // Returns true iff node's label is already the store
@@ -137,18 +311,8 @@ func (d *treeStore) IsLabelInStore(id Identifier) (bool, error) {
 		return false, err
 	}
 
-	stats, err := d.file.Stat()
-	if err != nil {
-		return false, err
-	}
-
-	if d.bw.Buffered() > 0 && idx < (d.c*WB) {
-		// label is in file or in the buffer
-		return true, nil
-	}
-
-	fileSize := uint64(stats.Size())
-	return idx < fileSize, nil
+	// labels are appended so the label is in the file or in the buffer iff it was written
+	return idx < d.c*d.wb, nil
 }
 
 // Read label value from the store
@@ -156,7 +320,7 @@ func (d *treeStore) IsLabelInStore(id Identifier) (bool, error) {
 func (d *treeStore) Read(id Identifier) (Label, error) {
 
 	// fixed size label
-	label := make(Label, WB)
+	label := make(Label, d.wb)
 
 	// total # of labels written - # of buffered labels == idx of label at buff start
 	// say 4 labels were written, and Buffered() is 64 bytes. 2 last labels
@@ -169,21 +333,65 @@ func (d *treeStore) Read(id Identifier) (Label, error) {
 		return label, err
 	}
 
-	n, err := d.file.ReadAt(label, int64(idx))
+	n, err := d.backend.ReadAt(label, int64(HeaderSize+idx))
 	if err != nil {
 		return label, err
 	}
 
-	if n != WB {
-		return label, errors.New("failed to read WB bytes from store at provided offset")
+	if uint64(n) != d.wb {
+		return label, errors.New("failed to read a label from store at provided offset")
 	}
 
 	return label, nil
 }
 
+// Read the labels of ids with a batch of sorted, coalesced and concurrent reads
+func (d *treeStore) ReadBatch(ids []Identifier) (Labels, error) {
+	return readLabels(d.backend, d.wb, ids, d.calcFileIndex)
+}
+
+// Read the labels of ids from a tree store file in one batch
+// offset returns the offset of a label from the end of the header or ErrLabelNotStored for omitted labels
+func readLabels(r io.ReaderAt, wb uint64, ids []Identifier, offset func(id Identifier) (uint64, error)) (Labels, error) {
+
+	res := make(Labels, len(ids))
+
+	// stored labels requests and their ids indices
+	reqs := make([]readRequest, 0, len(ids))
+	idx := make([]int, 0, len(ids))
+
+	for i, id := range ids {
+		off, err := offset(id)
+		if err == ErrLabelNotStored {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, readRequest{int64(HeaderSize + off), int(wb)})
+		idx = append(idx, i)
+	}
+
+	data, err := readBatch(r, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, l := range data {
+		res[idx[i]] = l
+	}
+
+	return res, nil
+}
+
 // Returns the bytes file offset for a node id i
+// Returns ErrLabelNotStored if the node is in an omitted level
 func (d *treeStore) calcFileIndex(id Identifier) (uint64, error) {
 
+	if uint(len(id))+d.k > d.n {
+		return 0, ErrLabelNotStored
+	}
+
 	// TODO: this can be heavily optimized and implemented w/o recursion or allocation of binary strings
 
 	s := d.subtreeSize(id)
@@ -193,17 +401,17 @@ func (d *treeStore) calcFileIndex(id Identifier) (uint64, error) {
 	}
 
 	idx := s + s1 - 1
-	offset := idx * WB
+	offset := idx * d.wb
 
 	//fmt.Printf("Node id %s. Index: %d. Offset: %d\n", id, idx, offset)
 	return offset, nil
 }
 
-// Returns the size of the subtree rooted at node id
+// Returns the # of stored labels of the subtree rooted at node id
 func (d *treeStore) subtreeSize(id Identifier) uint64 {
 	// node depth is the number of bits in its id
 	depth := uint(len(id))
-	height := d.n - depth
+	height := d.n - d.k - depth
 	return uint64(math.Pow(2, float64(height+1)) - 1)
 }
 
@@ -0,0 +1,172 @@
+package post
+
+import (
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Both tree store layouts must behave the same
+func TestTreeStoreLayouts(t *testing.T) {
+	var proofs MerkleProofs
+	for _, layout := range []TreeLayout{LayoutDepthFirst, LayoutLevelOrder} {
+		for _, k := range []uint{0, 2} {
+			testTreeStore(t, layout, k)
+
+			proofs1 := testMerkleLayout(t, layout, k)
+			if proofs != nil {
+				assert.Equal(t, proofs, proofs1, "layout: %s. omitted levels: %d", layout, k)
+			}
+			proofs = proofs1
+		}
+	}
+}
+
+// Returns the ids of the nodes of a tree of height n which are at least k levels above the leaves in
+// depth-first post-order
+func postOrderIds(n uint, k uint, id Identifier, ids *[]Identifier) {
+	if uint(len(id))+k < n {
+		postOrderIds(n, k, id+"0", ids)
+		postOrderIds(n, k, id+"1", ids)
+	}
+	*ids = append(*ids, id)
+}
+
+func testTreeStore(t *testing.T, layout TreeLayout, k uint) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	fileName := filepath.Join(currFolder, "tree_store.bin")
+
+	const n = uint64(8)
+	hdr := NewHeader(util.Rnd(t, 32), n, 4, hashing.SHA256)
+	hdr.OmittedLevels = k
+	hdr.Layout = layout
+
+	var ids []Identifier
+	postOrderIds(uint(n-1), k, rootId, &ids)
+
+	labels := make(map[Identifier]Label, len(ids))
+	for _, id := range ids {
+		labels[id] = util.Rnd(t, 32)
+	}
+
+	w, err := NewTreeStoreWriter(fileName, hdr)
+	assert.NoError(t, err)
+
+	// write the first half of the labels and resume writing the rest
+	for _, id := range ids[:len(ids)/2] {
+		assert.NoError(t, w.Write(id, labels[id]))
+	}
+	assert.Equal(t, uint64(len(ids)/2*32), w.Size(), layout.String())
+
+	ok, err := w.IsLabelInStore(ids[len(ids)/2-1])
+	assert.NoError(t, err)
+	assert.True(t, ok, layout.String())
+	ok, err = w.IsLabelInStore(ids[len(ids)/2])
+	assert.NoError(t, err)
+	assert.False(t, ok, layout.String())
+	assert.NoError(t, w.Close())
+
+	w, err = OpenTreeStoreWriter(fileName, hdr, uint64(len(ids)/2))
+	assert.NoError(t, err)
+	for _, id := range ids[len(ids)/2:] {
+		assert.NoError(t, w.Write(id, labels[id]))
+	}
+	ok, err = w.IsLabelInStore(rootId)
+	assert.NoError(t, err)
+	assert.True(t, ok, layout.String())
+	assert.NoError(t, w.Close())
+
+	r, err := NewTreeStoreReader(fileName, uint(n-1))
+	assert.NoError(t, err)
+	for _, id := range ids {
+		l, err := r.Read(id)
+		assert.NoError(t, err)
+		assert.Equal(t, labels[id], l, "layout: %s. omitted levels: %d. node: %s", layout, k, id)
+	}
+
+	// labels of omitted levels are not in the store
+	if k > 0 {
+		_, err = r.Read(nodeId(0, uint(n-1)))
+		assert.Equal(t, ErrLabelNotStored, err)
+	}
+	assert.NoError(t, r.Close())
+
+	// another layout can't be resumed
+	hdr.Layout = 1 - layout
+	_, err = OpenTreeStoreWriter(fileName, hdr, 1)
+	assert.Error(t, err, layout.String())
+}
+
+// Write the merkle tree of a fixed table using the provided layout and omitted levels
+// Returns the proofs of fixed indices
+func testMerkleLayout(t *testing.T, layout TreeLayout, k uint) MerkleProofs {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	mf := filepath.Join(currFolder, "merkle_layout.bin")
+
+	const n, l = uint64(9), uint(6)
+
+	id := []byte("merkle layout test id")
+	h := hashing.NewHashFunc(id)
+
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]uint64, 1<<n)
+	for i := range data {
+		data[i] = rnd.Uint64() & (1<<l - 1)
+	}
+	sr := NewMemoryStoreReader(data)
+
+	var expected []byte
+	root := postOrderLabels(h, data, &expected)
+
+	hdr := NewHeader(id, n, l, h.Id())
+	hdr.OmittedLevels = k
+	hdr.Layout = layout
+
+	indices := make([]*big.Int, 10)
+	for i := range indices {
+		indices[i] = big.NewInt(rnd.Int63n(1 << n))
+	}
+	var proofs MerkleProofs
+
+	stored := uint64(1)<<(uint(n)-k) - 1
+	for c := uint64(0); c <= stored; c += 1 + stored/5 {
+
+		mw, err := OpenMerkleTreeWriter(sr, mf, hdr, h, c, nil)
+		assert.NoError(t, err)
+		comm, err := mw.Write()
+		assert.NoError(t, err)
+		assert.Equal(t, root, comm, "layout: %s. omitted levels: %d. resumed from %d labels", layout, k, c)
+
+		mr, err := newMerkleTreeReader(sr, mf, l, uint(n-1), h)
+		assert.NoError(t, err)
+		proofs1, err := mr.ReadProofs(indices)
+		assert.NoError(t, err)
+		assert.NoError(t, mr.Close())
+
+		if proofs != nil {
+			assert.Equal(t, proofs, proofs1, "layout: %s. omitted levels: %d. resumed from %d labels", layout, k, c)
+		}
+		proofs = proofs1
+	}
+
+	hdr1, err := ReadMerkleHeader(mf)
+	assert.NoError(t, err)
+	assert.Equal(t, layout, hdr1.Layout)
+
+	return proofs
+}
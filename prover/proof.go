@@ -1,8 +1,418 @@
 package prover
 
-import "github.com/avive/rpost/post"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/avive/rpost/bstring"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
+	"github.com/avive/rpost/util"
+	"strconv"
+)
 
 type Proof struct {
-	Nonces       []uint64
-	MerkleProofs []post.MerkleProofs
+	N           uint64        // table size T = 2^n the proof was generated for
+	L           uint          // # of bits stored per table entry
+	Hash        byte          // id of the hash backend of Hx()
+	Labeling    post.Labeling // labeling of the table entries the iPoWs are computed with
+	Nonces      []uint64
+	MultiProofs []*post.MultiProof // MultiProofs[j] opens the store entries of nonce j
+	Data        [][]uint64         // Data[j][t] is the store entry at MultiProofs[j].Indices[t]
+}
+
+// Proof binary encoding
+//
+// All ints are big-endian. db := ceil(l/8)
+//
+// header:
+//   version   uint8
+//   n         uint8
+//   l         uint8
+//   hash      uint8 - hash backend id
+//   labeling  13 bytes - table entries labeling. See post.Labeling.Bytes()
+//   k         uint16 - # of nonces
+//   length    uint32 - payload length in bytes
+// payload, for each nonce j:
+//   nonce     uint64
+//   count     uint16 - # of opened store entries
+//   for each opened store entry t in increasing index order:
+//     index   ceil(n/8) bytes - MultiProofs[j].Indices[t]
+//     data    db bytes - Data[j][t]
+//   nodes     uint16 - # of multi proof nodes
+//   for each node ordered by decreasing depth and increasing position:
+//     depth   uint8
+//     pos     ceil(depth/8) bytes
+//     label   db bytes - store entry value at depth n. wb bytes - the output size of the hash backend above it
+//
+// Node ids are not encoded as binary strings - only a node depth and its position in its tree level are encoded
+
+const (
+	ProofVersion    = 3
+	proofHeaderSize = 4 + post.LabelingSize + 6
+	kOffset         = 4 + post.LabelingSize
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported proof encoding version")
+	ErrInvalidParams      = errors.New("invalid proof params n or l")
+	ErrUnknownHash        = errors.New("unknown proof hash backend")
+	ErrInvalidLabeling    = errors.New("invalid proof labeling")
+	ErrTruncated          = errors.New("proof data is truncated")
+	ErrTrailingData       = errors.New("unexpected trailing data after proof")
+	ErrInvalidLength      = errors.New("proof payload length doesn't match header")
+	ErrInvalidPath        = errors.New("invalid merkle path in proof")
+	ErrInvalidLabel       = errors.New("invalid node label in proof")
+	ErrInvalidData        = errors.New("store entry value doesn't fit in l bits")
+	ErrTooLarge           = errors.New("proof has too many nonces or openings")
+)
+
+// Returns the max encoded size in bytes of a proof of K nonces each opening K store entries for table params n
+// and l. Openings of a nonce share merkle nodes so proofs are usually smaller
+func MaxEncodedSize(n uint64, l uint) uint64 {
+	k := uint64(post.K)
+	db := bytesLen(uint64(l))
+	wb := uint64(post.WB)
+
+	res := 8 + 2 + k*(bytesLen(n)+db) + 2
+	for d := uint64(1); d <= n; d++ {
+		// a sibling is needed for each parent with one opened child
+		c := k
+		if d <= 63 && uint64(1)<<(d-1) < k {
+			c = uint64(1) << (d - 1)
+		}
+
+		size := 1 + bytesLen(d) + wb
+		if d == n {
+			size = 1 + bytesLen(d) + db
+		}
+		res += c * size
+	}
+
+	return proofHeaderSize + k*res
+}
+
+// Returns the # of bytes needed to store b bits
+func bytesLen(b uint64) uint64 {
+	return (b + 7) / 8
+}
+
+// Write the lsb b bytes of v to buff using big-endian encoding
+func putUint(buff []byte, v uint64, b uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(buff, tmp[8-b:]...)
+}
+
+// Read a b bytes long big-endian uint from data
+func getUint(data []byte, b uint64) uint64 {
+	var tmp [8]byte
+	copy(tmp[8-b:], data[:b])
+	return binary.BigEndian.Uint64(tmp[:])
+}
+
+func validParams(n uint64, l uint) bool {
+	return n >= 9 && n <= 63 && l >= 1 && l <= 63
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (p *Proof) MarshalBinary() ([]byte, error) {
+
+	if !validParams(p.N, p.L) {
+		return nil, ErrInvalidParams
+	}
+
+	b, err := hashing.GetBackend(p.Hash)
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+
+	if p.Labeling.Validate() != nil {
+		return nil, ErrInvalidLabeling
+	}
+
+	if len(p.Nonces) != len(p.MultiProofs) || len(p.Nonces) != len(p.Data) || len(p.Nonces) > 0xffff {
+		return nil, ErrTooLarge
+	}
+
+	db := bytesLen(uint64(p.L))
+	wb := uint64(b.Size)
+
+	// encoded size from the actual # of opened entries and nodes of each nonce
+	size := uint64(proofHeaderSize)
+	for _, mp := range p.MultiProofs {
+		if mp == nil {
+			return nil, ErrInvalidPath
+		}
+		size += 8 + 2 + uint64(len(mp.Indices))*(bytesLen(p.N)+db) + 2 + uint64(len(mp.Nodes))*(1+bytesLen(p.N)+wb)
+	}
+	buff := make([]byte, proofHeaderSize, size)
+
+	for j, nonce := range p.Nonces {
+
+		mp := p.MultiProofs[j]
+		if len(mp.Indices) != len(p.Data[j]) || len(mp.Indices) > 0xffff || len(mp.Nodes) > 0xffff {
+			return nil, ErrTooLarge
+		}
+
+		buff = putUint(buff, nonce, 8)
+		buff = putUint(buff, uint64(len(mp.Indices)), 2)
+
+		for t, idx := range mp.Indices {
+			if idx>>p.N != 0 || (t > 0 && idx <= mp.Indices[t-1]) {
+				return nil, ErrInvalidPath
+			}
+			buff = putUint(buff, idx, bytesLen(p.N))
+
+			v := p.Data[j][t]
+			if v>>p.L != 0 {
+				return nil, ErrInvalidData
+			}
+			buff = putUint(buff, v, db)
+		}
+
+		buff = putUint(buff, uint64(len(mp.Nodes)), 2)
+
+		var prev nodePos
+		for i, node := range mp.Nodes {
+
+			d := uint64(len(node.Id))
+			if d == 0 || d > p.N {
+				return nil, ErrInvalidPath
+			}
+
+			pos, err := strconv.ParseUint(string(node.Id), 2, 64)
+			if err != nil {
+				return nil, ErrInvalidPath
+			}
+
+			cur := nodePos{d, pos}
+			if i > 0 && !prev.before(cur) {
+				return nil, ErrInvalidPath
+			}
+			prev = cur
+
+			buff = append(buff, byte(d))
+			buff = putUint(buff, pos, bytesLen(d))
+
+			if d == p.N {
+				// sibling store entry label is the canonical encoding of an l bits value
+				if len(node.Label) == 0 || len(node.Label) > 8 {
+					return nil, ErrInvalidLabel
+				}
+				sv := getUint(node.Label, uint64(len(node.Label)))
+				if sv>>p.L != 0 || !bytes.Equal(util.EncodeToBytes(sv), node.Label) {
+					return nil, ErrInvalidLabel
+				}
+				buff = putUint(buff, sv, db)
+			} else {
+				if uint64(len(node.Label)) != wb {
+					return nil, ErrInvalidLabel
+				}
+				buff = append(buff, node.Label...)
+			}
+		}
+	}
+
+	buff[0] = ProofVersion
+	buff[1] = byte(p.N)
+	buff[2] = byte(p.L)
+	buff[3] = p.Hash
+	copy(buff[4:], p.Labeling.Bytes())
+	binary.BigEndian.PutUint16(buff[kOffset:], uint16(len(p.Nonces)))
+	binary.BigEndian.PutUint32(buff[kOffset+2:], uint32(len(buff)-proofHeaderSize))
+
+	return buff, nil
+}
+
+// A multi proof node depth and position
+type nodePos struct {
+	depth uint64
+	pos   uint64
+}
+
+// Returns true iff a node at p is ordered before a node at o - by decreasing depth and increasing position
+func (p nodePos) before(o nodePos) bool {
+	return p.depth > o.depth || (p.depth == o.depth && p.pos < o.pos)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (p *Proof) UnmarshalBinary(data []byte) error {
+
+	if len(data) < proofHeaderSize {
+		return ErrTruncated
+	}
+
+	if data[0] != ProofVersion {
+		return ErrUnsupportedVersion
+	}
+
+	n := uint64(data[1])
+	l := uint(data[2])
+	if !validParams(n, l) {
+		return ErrInvalidParams
+	}
+
+	b, err := hashing.GetBackend(data[3])
+	if err != nil {
+		return ErrUnknownHash
+	}
+
+	lb, err := post.ParseLabeling(data[4:kOffset])
+	if err != nil {
+		return ErrInvalidLabeling
+	}
+
+	k := int(binary.BigEndian.Uint16(data[kOffset:]))
+	length := binary.BigEndian.Uint32(data[kOffset+2:])
+
+	payload := data[proofHeaderSize:]
+	if uint64(len(payload)) < uint64(length) {
+		return ErrTruncated
+	}
+	if uint64(len(payload)) > uint64(length) {
+		return ErrTrailingData
+	}
+
+	f := bstring.NewSMBinaryStringFactory()
+	db := bytesLen(uint64(l))
+	r := &proofReader{data: payload}
+
+	res := Proof{
+		N:           n,
+		L:           l,
+		Hash:        b.Id,
+		Labeling:    lb,
+		Nonces:      make([]uint64, k),
+		MultiProofs: make([]*post.MultiProof, k),
+		Data:        make([][]uint64, k),
+	}
+
+	for j := 0; j < k; j++ {
+
+		nonce, err := r.readUint(8)
+		if err != nil {
+			return err
+		}
+		res.Nonces[j] = nonce
+
+		c, err := r.readUint(2)
+		if err != nil {
+			return err
+		}
+
+		mp := &post.MultiProof{Indices: make([]uint64, c)}
+		dj := make([]uint64, c)
+
+		for t := range mp.Indices {
+
+			idx, err := r.readUint(bytesLen(n))
+			if err != nil {
+				return err
+			}
+			if idx>>n != 0 || (t > 0 && idx <= mp.Indices[t-1]) {
+				return ErrInvalidPath
+			}
+			mp.Indices[t] = idx
+
+			v, err := r.readUint(db)
+			if err != nil {
+				return err
+			}
+			if v>>l != 0 {
+				return ErrInvalidData
+			}
+			dj[t] = v
+		}
+
+		nodes, err := r.readUint(2)
+		if err != nil {
+			return err
+		}
+
+		mp.Nodes = make([]post.Node, nodes)
+
+		var prev nodePos
+		for i := range mp.Nodes {
+
+			d, err := r.readUint(1)
+			if err != nil {
+				return err
+			}
+			if d == 0 || d > n {
+				return ErrInvalidPath
+			}
+
+			pos, err := r.readUint(bytesLen(d))
+			if err != nil {
+				return err
+			}
+			if pos>>d != 0 {
+				return ErrInvalidPath
+			}
+
+			cur := nodePos{d, pos}
+			if i > 0 && !prev.before(cur) {
+				return ErrInvalidPath
+			}
+			prev = cur
+
+			id, err := f.NewBinaryStringFromInt(pos, uint(d))
+			if err != nil {
+				return err
+			}
+
+			var label post.Label
+			if d == n {
+				sv, err := r.readUint(db)
+				if err != nil {
+					return err
+				}
+				if sv>>l != 0 {
+					return ErrInvalidLabel
+				}
+				label = util.EncodeToBytes(sv)
+			} else {
+				lb, err := r.read(uint64(b.Size))
+				if err != nil {
+					return err
+				}
+				label = append(post.Label{}, lb...)
+			}
+
+			mp.Nodes[i] = post.Node{Id: post.Identifier(id.GetStringValue()), Label: label}
+		}
+
+		res.MultiProofs[j] = mp
+		res.Data[j] = dj
+	}
+
+	if len(r.data) != 0 {
+		return ErrInvalidLength
+	}
+
+	*p = res
+	return nil
+}
+
+// A simple reader consuming a proof payload
+type proofReader struct {
+	data []byte
+}
+
+func (r *proofReader) read(b uint64) ([]byte, error) {
+	if uint64(len(r.data)) < b {
+		return nil, ErrTruncated
+	}
+	res := r.data[:b]
+	r.data = r.data[b:]
+	return res, nil
+}
+
+func (r *proofReader) readUint(b uint64) (uint64, error) {
+	buff, err := r.read(b)
+	if err != nil {
+		return 0, err
+	}
+	return getUint(buff, b), nil
 }
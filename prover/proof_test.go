@@ -0,0 +1,166 @@
+package prover
+
+import (
+	"github.com/avive/rpost/bstring"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestProofEncoding(t *testing.T) {
+
+	p := newRandomProof(t, 12, 20, 3, 5)
+
+	data, err := p.MarshalBinary()
+	assert.NoError(t, err)
+
+	p1 := &Proof{}
+	err = p1.UnmarshalBinary(data)
+	assert.NoError(t, err)
+	assert.Equal(t, p, p1)
+
+	// encoding should be canonical
+	data1, err := p1.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, data, data1)
+}
+
+func TestProofEncodedSize(t *testing.T) {
+
+	K := post.K
+	p := newRandomProof(t, 9, 4, K, K)
+
+	data, err := p.MarshalBinary()
+	assert.NoError(t, err)
+	assert.True(t, uint64(len(data)) <= MaxEncodedSize(9, 4))
+
+	for _, n := range []uint64{20, 30, 40} {
+		for _, l := range []uint{8, 16, 20} {
+			t.Logf("n: %d, l: %d - max proof size: %d bytes\n", n, l, MaxEncodedSize(n, l))
+		}
+	}
+}
+
+func TestProofDecodingErrors(t *testing.T) {
+
+	const n = 10
+	p := newRandomProof(t, n, 6, 2, 2)
+	data, err := p.MarshalBinary()
+	assert.NoError(t, err)
+
+	p1 := &Proof{}
+
+	assert.Equal(t, ErrTruncated, p1.UnmarshalBinary(data[:5]))
+	assert.Equal(t, ErrTruncated, p1.UnmarshalBinary(data[:len(data)-1]))
+	assert.Equal(t, ErrTrailingData, p1.UnmarshalBinary(append(append([]byte{}, data...), 0)))
+
+	bad := append([]byte{}, data...)
+	bad[0] = ProofVersion + 1
+	assert.Equal(t, ErrUnsupportedVersion, p1.UnmarshalBinary(bad))
+
+	bad = append([]byte{}, data...)
+	bad[1] = 64
+	assert.Equal(t, ErrInvalidParams, p1.UnmarshalBinary(bad))
+
+	bad = append([]byte{}, data...)
+	bad[3] = 0
+	assert.Equal(t, ErrUnknownHash, p1.UnmarshalBinary(bad))
+
+	bad = append([]byte{}, data...)
+	bad[4] = 0xff
+	assert.Equal(t, ErrInvalidLabeling, p1.UnmarshalBinary(bad))
+
+	// first opened index doesn't fit in n bits
+	bad = append([]byte{}, data...)
+	bad[proofHeaderSize+10] = 0xff
+	assert.Equal(t, ErrInvalidPath, p1.UnmarshalBinary(bad))
+
+	// opened indices aren't increasing
+	bad = append([]byte{}, data...)
+	copy(bad[proofHeaderSize+13:], data[proofHeaderSize+10:proofHeaderSize+12])
+	assert.Equal(t, ErrInvalidPath, p1.UnmarshalBinary(bad))
+
+	// first data entry doesn't fit in l bits
+	bad = append([]byte{}, data...)
+	bad[proofHeaderSize+12] = 0xff
+	assert.Equal(t, ErrInvalidData, p1.UnmarshalBinary(bad))
+
+	// first node depth is out of range
+	bad = append([]byte{}, data...)
+	bad[proofHeaderSize+18] = n + 1
+	assert.Equal(t, ErrInvalidPath, p1.UnmarshalBinary(bad))
+
+	// non canonical store entry label can't be encoded
+	for i, node := range p.MultiProofs[0].Nodes {
+		if len(node.Id) == n {
+			p.MultiProofs[0].Nodes[i].Label = []byte{0, 1}
+			break
+		}
+	}
+	_, err = p.MarshalBinary()
+	assert.Equal(t, ErrInvalidLabel, err)
+}
+
+// Returns a random SHA-256 proof with k nonces each opening c distinct store entries for params n, l
+func newRandomProof(t *testing.T, n uint64, l uint, k int, c int) *Proof {
+
+	f := bstring.NewSMBinaryStringFactory()
+	mask := uint64(1)<<l - 1
+
+	p := &Proof{
+		N:           n,
+		L:           l,
+		Hash:        hashing.SHA256,
+		Labeling:    post.NewArgon2idLabeling(1, 8, 1),
+		Nonces:      make([]uint64, k),
+		MultiProofs: make([]*post.MultiProof, k),
+		Data:        make([][]uint64, k),
+	}
+
+	for j := 0; j < k; j++ {
+		p.Nonces[j] = rand.Uint64()
+
+		// random merkle paths of c distinct entries which no 2 are siblings
+		opened := make(map[uint64]bool)
+		indices := make([]*big.Int, 0, c)
+		paths := make(post.MerkleProofs, 0, c)
+		for len(indices) < c {
+			idx := rand.Uint64() & (1<<n - 1)
+			if opened[idx] || opened[idx^1] {
+				continue
+			}
+			opened[idx] = true
+
+			path := make(post.MerkleProof, n)
+			for d := uint(n); d > 0; d-- {
+				id, err := f.NewBinaryStringFromInt((idx>>(uint(n)-d))^1, d)
+				assert.NoError(t, err)
+
+				label := post.Label(util.EncodeToBytes(rand.Uint64() & mask))
+				if d != uint(n) {
+					label = util.Rnd(t, 32)
+				}
+
+				path[uint(n)-d] = post.Node{Id: post.Identifier(id.GetStringValue()), Label: label}
+			}
+
+			indices = append(indices, new(big.Int).SetUint64(idx))
+			paths = append(paths, path)
+		}
+
+		mp, err := post.NewMultiProof(indices, paths, uint(n))
+		assert.NoError(t, err)
+		p.MultiProofs[j] = mp
+
+		p.Data[j] = make([]uint64, len(mp.Indices))
+		for i := range p.Data[j] {
+			p.Data[j][i] = rand.Uint64() & mask
+		}
+	}
+
+	return p
+}
@@ -1,7 +1,7 @@
 package prover
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/avive/rpost/hashing"
 	"github.com/avive/rpost/post"
@@ -10,150 +10,308 @@ import (
 	"math/big"
 )
 
-const K = post.K
-
 type Prover interface {
 	Prove(challenge []byte) (*Proof, error)
+
+	// Create a proof until done or ctx is done. Returns ctx.Err() when ctx is done first
+	ProveContext(ctx context.Context, challenge []byte) (*Proof, error)
+
+	SetLogger(l post.Logger)         // set the logger receiving the debug output. Provers are silent by default
+	SetProgress(f post.ProgressFunc) // set a func called after each proof attempt
+
+	// Set the labeling of the table entries recorded in proofs. File provers read it from the store header
+	SetLabeling(lb post.Labeling) error
 }
 
 type prover struct {
-	id []byte                // initial commitment
-	n  uint64                // n param 9 <= n <= 63 - table size is 2^n
-	l  uint                  // l param (num of leading 0s for p) := f(p). 1: 50%, 2: 25%, 3:12.5%...
+	p  *post.Params          // validated params of the table. e.g. id, n and l
 	h  hashing.HashFunc      // Hx()
+	lb post.Labeling         // table entries labeling
 	sr post.StoreReader      // Store reader can read data from the store at any index
 	mr post.MerkleTreeReader // Merkle tree reader can read nodes on the path from an identified nodes the root
+
+	log      post.Logger       // debug output. Silent when nil
+	progress post.ProgressFunc // called after each proof attempt. nil for none
 }
 
 // n - size of data store => T=2^n
-func NewProver(id []byte, n uint64, l uint, h hashing.HashFunc, storeFile string, merkleFile string) (Prover, error) {
+// opts configure the store and merkle tree readers. e.g. post.WithMmap()
+func NewProver(id []byte, n uint64, l uint, h hashing.HashFunc, storeFile string, merkleFile string,
+	opts ...post.ReaderOption) (Prover, error) {
+
+	p, err := post.NewParams(id, n, l, h.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	return newProver(p, h, storeFile, merkleFile, opts...)
+}
+
+// Returns a prover of the table with params p which store and merkle tree are read from storeFile and merkleFile
+func NewProverWithParams(p *post.Params, storeFile string, merkleFile string, opts ...post.ReaderOption) (Prover, error) {
+
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return newProver(p, h, storeFile, merkleFile, opts...)
+}
+
+func newProver(p *post.Params, h hashing.HashFunc, storeFile string, merkleFile string,
+	opts ...post.ReaderOption) (Prover, error) {
+
+	// the readers validate that the store and merkle files were created for this table
+	sr, err := post.NewStoreReader(storeFile, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// merkle tree height is n-1, so |merkle leafs| = 2^(n-1)
+	mr, err := post.NewMerkleTreeReader(sr, merkleFile, p, opts...)
+	if err != nil {
+		sr.Close()
+		return nil, err
+	}
+
+	sh, err := post.ReadStoreHeader(storeFile)
+	if err == nil {
+		var mh *post.Header
+		mh, err = post.ReadMerkleHeader(merkleFile)
+		if err == nil && mh.Labeling != sh.Labeling {
+			err = fmt.Errorf("%s: table labeling %s doesn't match the store labeling %s", merkleFile, mh.Labeling,
+				sh.Labeling)
+		}
+	}
+	if err != nil {
+		mr.Close()
+		sr.Close()
+		return nil, err
+	}
+
+	return &prover{p: p, h: h, lb: sh.Labeling, sr: sr, mr: mr}, nil
+}
+
+// Returns a prover reading the store and merkle tree of a table from the shards of the manifest manifestFile
+func NewShardedProver(p *post.Params, manifestFile string, opts ...post.ReaderOption) (Prover, error) {
+
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	// the store reader validates that the manifest and its shards were created for this table
+	sr, err := post.NewShardedStoreReader(manifestFile, p, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	if n < 9 {
-		return nil, errors.New("n must be >= 9")
+	m, err := post.ReadManifest(manifestFile)
+	if err != nil {
+		sr.Close()
+		return nil, err
+	}
+
+	lb, err := m.Labeling()
+	if err != nil {
+		sr.Close()
+		return nil, err
+	}
+
+	mr, err := post.NewShardedTreeStoreReader(manifestFile, opts...)
+	if err != nil {
+		sr.Close()
+		return nil, err
+	}
+
+	mtr, err := post.NewMerkleTreeReaderWithStore(sr, mr, p)
+	if err != nil {
+		mr.Close()
+		sr.Close()
+		return nil, err
 	}
 
-	sr, err := post.NewStoreReader(storeFile, uint(n))
+	return &prover{p: p, h: h, lb: lb, sr: sr, mr: mtr}, nil
+}
+
+// Returns a prover reading the table store from sr and its merkle tree from mr
+// The readers may be of in-memory stores. e.g. post.MemoryStore and post.MemoryTreeStore
+func NewProverWithReaders(p *post.Params, sr post.StoreReader, mr post.MerkleTreeReader) (Prover, error) {
+
+	err := p.Validate()
 	if err != nil {
 		return nil, err
 	}
 
-	// merkle tree height is n-1, so |merkle leafs| = 2^(n01)
-	mr, err := post.NewMerkleTreeReader(sr, merkleFile, l, uint(n-1), h)
+	h, err := p.HashFunc()
 	if err != nil {
 		return nil, err
 	}
 
 	prover := &prover{
-		id, n, l, h, sr, mr,
+		p: p, h: h, sr: sr, mr: mr,
 	}
 
 	return prover, nil
 }
 
+func (p *prover) SetLogger(l post.Logger) {
+	p.log = l
+}
+
+func (p *prover) SetProgress(f post.ProgressFunc) {
+	p.progress = f
+}
+
+func (p *prover) SetLabeling(lb post.Labeling) error {
+	err := lb.Validate()
+	if err != nil {
+		return err
+	}
+	p.lb = lb
+	return nil
+}
+
+func (p *prover) logf(format string, v ...interface{}) {
+	if p.log != nil {
+		p.log.Printf(format, v...)
+	}
+}
+
 func (p *prover) Prove(challenge []byte) (*Proof, error) {
+	return p.ProveContext(context.Background(), challenge)
+}
+
+// Create a proof for challenge until done or ctx is done. The store and merkle tree readers are left open
+func (p *prover) ProveContext(ctx context.Context, challenge []byte) (*Proof, error) {
 
 	// implements the prover proof phase described in page 9 of the paper
 
-	fmt.Printf("Creating proof for challenge 0x%x...\n", challenge)
+	p.logf("Creating proof for challenge 0x%x...", challenge)
 
 	// table size as big int
-	T := big.NewInt(int64(math.Pow(2, float64(p.n))))
+	T := GetTableSize(p.p.N)
 
-	// holds i(j,t) indexes as defined in page 9
-	indices := make([]*big.Int, K)
+	K := p.p.K
 
 	// holds nonce(j)
 	nonces := make([]uint64, K)
 
-	temp := new(big.Int)
+	// hold K merkle multi proofs. e.g. Phi(decommit(i))
+	mps := make([]*post.MultiProof, K)
 
-	// hold K merkle paths. e.g. Phi(decommit(i))
-
-	mpaths := make([]post.MerkleProofs, K)
+	// holds the store entries opened by each multi proof
+	data := make([][]uint64, K)
 
 	// compute big int mask for pathProbe < phi calculations
-	phi := float64(K) / float64(T.Uint64())
-
-	fmt.Printf("Probability of finding pathprobe at least: %0.5f\n", phi)
-
-	diff := util.GetDifficulty(phi)
+	mask := util.GetMask(uint(p.h.Size()), p.p.PathProbeDifficulty)
+	p.logf("Mask : 0x%x", mask.Bytes())
 
-	fmt.Printf("Difficulty (expected # of trials): %d\n", diff)
-
-	mask := util.GetMask(32, diff)
-	fmt.Printf("Mask : 0x%x\n", mask.Bytes())
+	// # of proof attempts
+	var attempts uint64
 
 	for j := 0; j < K; j++ {
 		nonce := uint64(0)
 
-		var mpj post.MerkleProofs
-		fmt.Printf("\n%d / %d\n", j, K)
+		var mpj *post.MultiProof
+		var dj []uint64
+
+		p.logf("%d / %d", j, K)
 		for {
-			fmt.Printf(".\n")
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 
 			nonce += 1
-			// fmt.Printf("[%d] Computing indices...\n", nonce)
-			for t := 0; t < K; t++ {
-				nb := util.EncodeToBytes(nonce)
-				d := p.h.Hash(nb, p.id, []byte{byte(j)}, []byte{byte(t)})
-				temp = temp.SetBytes(d)
-				indices[t] = new(big.Int).Set(temp.Mod(temp, T))
-			}
+			attempts += 1
 
-			// read merkle paths from the data at indices
-			// fmt.Printf("Reading proofs...\n")
+			// holds i(j,t) indexes as defined in page 9
+			indices := GetIndices(p.h, challenge, p.p.Id, nonce, j, T)
 
-			mpj, err := p.mr.ReadProofs(indices)
+			// read merkle paths from the data at indices and merge their shared nodes
+			paths, err := p.mr.ReadProofs(indices)
 			if err != nil {
 				return nil, err
 			}
 
-			// fmt.Printf("Computing path probe...\n")
+			mpj, err = post.NewMultiProof(indices, paths, uint(p.p.N))
+			if err != nil {
+				return nil, err
+			}
 
-			pathProbe, err := p.computePathProbe(indices, mpj)
+			// read the opened entries from the store
+			dj, err = p.sr.ReadUint64Batch(mpj.Indices)
 			if err != nil {
 				return nil, err
 			}
 
-			if pathProbe.Cmp(mask) <= 0 {
+			pathProbe := GetPathProbe(p.h, challenge, mpj, dj)
+			found := pathProbe.Cmp(mask) <= 0
+
+			if p.progress != nil {
+				done := uint64(j)
+				if found {
+					done += 1
+				}
+				p.progress(post.Progress{Entries: done, Total: uint64(K), Attempts: attempts})
+			}
+
+			if found {
 				break
 			}
 		}
 
-		mpaths[j] = mpj
+		mps[j] = mpj
 		nonces[j] = nonce
+		data[j] = dj
 	}
 
-	return &Proof{nonces, mpaths}, nil
+	return &Proof{p.p.N, p.p.L, p.h.Id(), p.lb, nonces, mps, data}, nil
 }
 
-func (p *prover) computePathProbe(indices []*big.Int, mpj post.MerkleProofs) (*big.Int, error) {
-
-	data := make([][]byte, K*3)
-
-	for i := 0; i < K; i++ {
-		data[i*2] = indices[i].Bytes()
+// Returns the table size T = 2^n as a big int
+func GetTableSize(n uint64) *big.Int {
+	return big.NewInt(int64(math.Pow(2, float64(n))))
+}
 
-		// read the data from the store
-		buff, err := p.sr.ReadBytes(indices[i].Uint64())
-		if err != nil {
-			return nil, err
-		}
-		data[i*2+1] = buff
+// Returns the K table indices i(j,t) := Hx(challenge, id, nonce, j, t) mod T for nonce of iteration j
+// The challenge is bound into each index so a proof can't be precomputed and replayed for another challenge
+func GetIndices(h hashing.HashFunc, challenge []byte, id []byte, nonce uint64, j int, T *big.Int) []*big.Int {
+	indices := make([]*big.Int, post.K)
+	in := post.NewHashInput(post.DomainIndex).Bytes(challenge).Bytes(id).Uint64(nonce).Uint64(uint64(j))
+	for t := range indices {
+		d := in.Uint64(uint64(t)).Hash(h)
+		temp := new(big.Int).SetBytes(d)
+		indices[t] = temp.Mod(temp, T)
 	}
+	return indices
+}
 
-	idx := K * 2
+// Returns pathProbe := Hx(challenge, i(0), data(0), ..., i(c-1), data(c-1), mp)
+// i(0) < ... < i(c-1) are the store entries opened by the multi proof mp and data[t] is the store entry at i(t)
+func GetPathProbe(h hashing.HashFunc, challenge []byte, mp *post.MultiProof, data []uint64) *big.Int {
 
-	var buf []byte
-	for _, path := range mpj {
-		for _, node := range path {
-			buf = append(buf, node.Label...)
-		}
-		data[idx] = buf
-		idx += 1
+	in := post.NewHashInput(post.DomainPathProbe).Bytes(challenge)
+
+	for t, idx := range mp.Indices {
+		in = in.Uint64(idx).Uint64(data[t])
 	}
 
-	digest := new(big.Int).SetBytes(p.h.HashSlices(data))
+	// node positions are implied by the opened entries
+	in = in.Uint64(uint64(len(mp.Nodes)))
+	for _, node := range mp.Nodes {
+		in = in.Bytes(node.Label)
+	}
 
-	return digest, nil
+	return new(big.Int).SetBytes(in.Hash(h))
 }
@@ -45,9 +45,9 @@ func testProver(t *testing.T, n uint64, l uint, postFileName string, merkleFileN
 	assert.NoError(t, err)
 
 	// Generate merkle tree from post store
-	sr, err := post.NewStoreReader(f, l)
+	sr, err := post.NewStoreReader(f, tbl.Params())
 	assert.NoError(t, err)
-	mw, err := post.NewMerkleTreeWriter(sr, mf, l, uint(n), h)
+	mw, err := post.NewMerkleTreeWriter(sr, mf, post.NewHeader(id, n, l, h.Id()), h)
 	assert.NoError(t, err)
 	comm, err := mw.Write()
 	assert.NoError(t, err)
@@ -71,3 +71,59 @@ func testProver(t *testing.T, n uint64, l uint, postFileName string, merkleFileN
 
 	assert.NoError(t, err)
 }
+
+func TestChallengeBinding(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(4)
+	f := filepath.Join(currFolder, "post2.bin")
+	mf := filepath.Join(currFolder, "merkle2.bin")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	tbl, err := post.NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	_, err = tbl.Store(mf)
+	assert.NoError(t, err)
+
+	pv, err := NewProver(id, n, l, h, f, mf)
+	assert.NoError(t, err)
+
+	c1 := util.Rnd(t, 32)
+	c2 := util.Rnd(t, 32)
+
+	// same nonce should derive different indices for different challenges
+	T := GetTableSize(n)
+	assert.NotEqual(t, GetIndices(h, c1, id, 1, 0, T), GetIndices(h, c2, id, 1, 0, T))
+
+	p1, err := pv.Prove(c1)
+	assert.NoError(t, err)
+
+	p2, err := pv.Prove(c2)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, p1.Data, p2.Data, "expected different proofs for different challenges")
+	assert.NotEqual(t, p1.MultiProofs, p2.MultiProofs, "expected different proofs for different challenges")
+}
+
+// Test vectors of the SHA-256 backend for commitment "rpost test vector id"
+func TestIndicesVectors(t *testing.T) {
+	id := []byte("rpost test vector id")
+	h := hashing.NewHashFunc(id)
+	challenge := []byte("challenge")
+
+	indices := GetIndices(h, challenge, id, 7, 3, GetTableSize(10))
+	assert.Equal(t, uint64(370), indices[0].Uint64())
+	assert.Equal(t, uint64(12), indices[1].Uint64())
+	assert.Equal(t, uint64(387), indices[255].Uint64())
+
+	leaf := post.LeafLabel(h, 1, 2)
+	mp := &post.MultiProof{Indices: []uint64{370}, Nodes: []post.Node{{Id: "1", Label: []byte{5}}, {Id: "0", Label: leaf}}}
+	probe := GetPathProbe(h, challenge, mp, []uint64{9})
+	assert.Equal(t, "c10bc53c3541fdafa4b66eeb30fb85b86567fe78e6c1a76266fb25433f5e97fe", fmt.Sprintf("%064x", probe))
+}
@@ -0,0 +1,159 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
+	"github.com/avive/rpost/prover"
+	"github.com/avive/rpost/util"
+	"math/big"
+	"sort"
+)
+
+// Verify implements the verifier verify phase described in page 9 of the paper
+// id - initial commitment
+// challenge - the challenge the proof was generated for
+// commitment - merkle root of the prover's store. e.g. MerkleTreeWriter.Write() result
+// n - table size T = 2^n
+// l - iPoW difficulty and the # of nonce bits stored per entry
+// h - Hx() the prover's table was built with
+// Returns nil iff proof is a valid proof for the challenge
+func Verify(id []byte, challenge []byte, commitment []byte, n uint64, l uint, h hashing.HashFunc, proof *prover.Proof) error {
+	return VerifyWithLabeling(id, challenge, commitment, n, l, h, post.Labeling{}, proof)
+}
+
+// Verify a proof of a table which entries are labeled using lb
+func VerifyWithLabeling(id []byte, challenge []byte, commitment []byte, n uint64, l uint, h hashing.HashFunc,
+	lb post.Labeling, proof *prover.Proof) error {
+
+	p, err := post.NewParams(id, n, l, h.Id())
+	if err != nil {
+		return err
+	}
+
+	return verify(p, h, lb, challenge, commitment, proof)
+}
+
+// Verify a proof of a table with params p which entries are labeled using lb
+func VerifyWithParams(p *post.Params, lb post.Labeling, challenge []byte, commitment []byte, proof *prover.Proof) error {
+
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	h, err := p.HashFunc()
+	if err != nil {
+		return err
+	}
+
+	return verify(p, h, lb, challenge, commitment, proof)
+}
+
+func verify(p *post.Params, h hashing.HashFunc, lb post.Labeling, challenge []byte, commitment []byte,
+	proof *prover.Proof) error {
+
+	err := lb.Validate()
+	if err != nil {
+		return err
+	}
+
+	if proof == nil {
+		return errors.New("nil proof")
+	}
+
+	if proof.Hash != p.HashId {
+		return fmt.Errorf("proof was created with hash backend %d. Expected %d", proof.Hash, p.HashId)
+	}
+
+	if proof.N != p.N || proof.L != p.L {
+		return fmt.Errorf("proof was created for n=%d l=%d. Expected n=%d l=%d", proof.N, proof.L, p.N, p.L)
+	}
+
+	if proof.Labeling != lb {
+		return fmt.Errorf("proof was created for labeling %s. Expected %s", proof.Labeling, lb)
+	}
+
+	id, n, l, K := p.Id, p.N, p.L, p.K
+
+	if len(proof.Nonces) != K || len(proof.MultiProofs) != K || len(proof.Data) != K {
+		return fmt.Errorf("expected %d nonces, merkle multi proofs and data sets", K)
+	}
+
+	T := prover.GetTableSize(n)
+	mask := util.GetMask(uint(h.Size()), p.PathProbeDifficulty)
+
+	for j := 0; j < K; j++ {
+
+		mpj := proof.MultiProofs[j]
+		dj := proof.Data[j]
+
+		if mpj == nil || len(dj) != len(mpj.Indices) {
+			return fmt.Errorf("expected a data entry for each entry opened by nonce %d", j)
+		}
+
+		// recompute i(j,t) from nonce(j). The multi proof must open exactly these entries
+		indices := prover.GetIndices(h, challenge, id, proof.Nonces[j], j, T)
+		if !openedIndices(indices, mpj.Indices) {
+			return fmt.Errorf("unexpected entries opened by nonce %d", j)
+		}
+
+		values := make([][]byte, len(dj))
+		for t, idx := range mpj.Indices {
+			if !verifyIPoW(h, lb, idx, dj[t], l) {
+				return fmt.Errorf("invalid iPoW for table entry %d (%d, %d)", idx, j, t)
+			}
+			values[t] = util.EncodeToBytes(dj[t])
+		}
+
+		err := mpj.Verify(h, values, commitment, uint(n))
+		if err != nil {
+			return fmt.Errorf("invalid merkle multi proof for nonce %d: %v", j, err)
+		}
+
+		pathProbe := prover.GetPathProbe(h, challenge, mpj, dj)
+		if pathProbe.Cmp(mask) > 0 {
+			return fmt.Errorf("pathProbe for nonce %d is above threshold", j)
+		}
+	}
+
+	return nil
+}
+
+// Returns true iff opened are the sorted unique indices
+func openedIndices(indices []*big.Int, opened []uint64) bool {
+	idx := make([]uint64, len(indices))
+	for i, v := range indices {
+		idx[i] = v.Uint64()
+	}
+	sort.Slice(idx, func(i, j int) bool { return idx[i] < idx[j] })
+
+	c := 0
+	for i, v := range idx {
+		if i > 0 && v == idx[i-1] {
+			continue
+		}
+		if c == len(opened) || opened[c] != v {
+			return false
+		}
+		c++
+	}
+	return c == len(opened)
+}
+
+// Returns true iff there's a permitted nonce which l lsb bits are v that is a valid iPoW for table entry idx
+func verifyIPoW(h hashing.HashFunc, lb post.Labeling, idx uint64, v uint64, l uint) bool {
+
+	maxNonce := post.GetMaxNonce(l)
+	step := new(big.Int).Lsh(big.NewInt(1), l)
+
+	// only the l lsb bits of nonce are stored so we try all nonces with these bits up to max nonce
+	for nonce := new(big.Int).SetUint64(v); nonce.Cmp(maxNonce) <= 0; nonce.Add(nonce, step) {
+		if post.IsValidIPoW(h, lb, idx, nonce, l) {
+			return true
+		}
+	}
+
+	return false
+}
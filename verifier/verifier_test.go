@@ -0,0 +1,227 @@
+package verifier
+
+import (
+	"context"
+	"github.com/avive/rpost/hashing"
+	"github.com/avive/rpost/post"
+	"github.com/avive/rpost/prover"
+	"github.com/avive/rpost/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	testVerifier(t, 9, 4, "post1.bin", "merkle1.bin")
+}
+
+// n - Table size T = 2^n
+// l - iPoW difficulty and the # of nonce bits to store per entry
+func testVerifier(t *testing.T, n uint64, l uint, postFileName string, merkleFileName string) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	f := filepath.Join(currFolder, postFileName)
+	mf := filepath.Join(currFolder, merkleFileName)
+
+	// Initial commitment
+	id := util.Rnd(t, 32)
+
+	// H(id) to be used for iPoW
+	h := hashing.NewHashFunc(id)
+
+	// Generate the store and the merkle tree
+	tbl, err := post.NewTable(id, n, l, h, f)
+	assert.NoError(t, err)
+	comm, err := tbl.Store(mf)
+	assert.NoError(t, err)
+
+	pv, err := prover.NewProver(id, n, l, h, f, mf)
+	assert.NoError(t, err)
+
+	challenge := util.Rnd(t, 32)
+	proof, err := pv.Prove(challenge)
+	assert.NoError(t, err)
+
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.NoError(t, err)
+
+	// proof should verify after an encoding round trip
+	data, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+	assert.True(t, uint64(len(data)) <= prover.MaxEncodedSize(n, l))
+	proof = &prover.Proof{}
+	err = proof.UnmarshalBinary(data)
+	assert.NoError(t, err)
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.NoError(t, err)
+
+	// proof should not verify against another commitment
+	err = Verify(id, challenge, util.Rnd(t, 32), n, l, h, proof)
+	assert.Error(t, err)
+
+	// proof should not verify for another challenge
+	err = Verify(id, util.Rnd(t, 32), comm, n, l, h, proof)
+	assert.Error(t, err)
+
+	// proof should not verify with a tampered data entry
+	proof.Data[3][7] ^= 1
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.Error(t, err)
+	proof.Data[3][7] ^= 1
+
+	// proof should not verify with a tampered merkle node
+	node := proof.MultiProofs[2].Nodes[len(proof.MultiProofs[2].Nodes)-1]
+	node.Label[0] ^= 1
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.Error(t, err)
+	node.Label[0] ^= 1
+
+	// proof should not verify with a tampered nonce
+	proof.Nonces[5] += 1
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.Error(t, err)
+}
+
+func TestVerifierLabeling(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	// with a small l almost every entry has some Hx() valid nonce with the stored lsb bits
+	const n, l = uint64(9), uint(8)
+
+	f := filepath.Join(currFolder, "post_labeling.bin")
+	mf := filepath.Join(currFolder, "merkle_labeling.bin")
+
+	for _, lb := range []post.Labeling{post.NewScryptLabeling(16, 1, 1), post.NewArgon2idLabeling(1, 8, 1)} {
+
+		id := util.Rnd(t, 32)
+		h := hashing.NewHashFunc(id)
+
+		tbl, err := post.NewTableWithLabeling(id, n, l, h, lb, f)
+		assert.NoError(t, err)
+		comm, err := tbl.Store(mf)
+		assert.NoError(t, err)
+
+		// the prover is the same for all labeling modes
+		pv, err := prover.NewProver(id, n, l, h, f, mf)
+		assert.NoError(t, err)
+
+		challenge := util.Rnd(t, 32)
+		proof, err := pv.Prove(challenge)
+		assert.NoError(t, err)
+
+		assert.Equal(t, lb, proof.Labeling)
+		err = VerifyWithLabeling(id, challenge, comm, n, l, h, lb, proof)
+		assert.NoError(t, err, lb.String())
+
+		// proof should not verify for a table labeled using cheap Hx()
+		err = Verify(id, challenge, comm, n, l, h, proof)
+		assert.Error(t, err, lb.String())
+		assert.Contains(t, err.Error(), "labeling")
+
+		// nor when its recorded labeling is replaced
+		proof.Labeling = post.Labeling{}
+		err = Verify(id, challenge, comm, n, l, h, proof)
+		assert.Error(t, err, lb.String())
+	}
+}
+
+// The whole store, prove and verify pipeline runs in memory
+func TestVerifierInMemory(t *testing.T) {
+
+	const n, l = uint64(9), uint(4)
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	ms := post.NewMemoryStore(l)
+	tbl, err := post.NewMemoryTable(id, n, l, h, post.Labeling{}, ms)
+	assert.NoError(t, err)
+	comm, ts, err := tbl.StoreInMemory()
+	assert.NoError(t, err)
+
+	pp, err := post.NewParams(id, n, l, h.Id())
+	assert.NoError(t, err)
+	mr, err := post.NewMerkleTreeReaderWithStore(ms, ts, pp)
+	assert.NoError(t, err)
+	pv, err := prover.NewProverWithReaders(pp, ms, mr)
+	assert.NoError(t, err)
+
+	var last post.Progress
+	pv.SetProgress(func(p post.Progress) {
+		last = p
+	})
+
+	challenge := util.Rnd(t, 32)
+	proof, err := pv.Prove(challenge)
+	assert.NoError(t, err)
+
+	K := uint64(post.K)
+	assert.Equal(t, K, last.Entries)
+	assert.Equal(t, K, last.Total)
+	assert.True(t, last.Attempts >= K)
+
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.NoError(t, err)
+
+	err = Verify(id, util.Rnd(t, 32), comm, n, l, h, proof)
+	assert.Error(t, err)
+
+	p, err := post.NewParams(id, n, l, h.Id())
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyWithParams(p, post.Labeling{}, challenge, comm, proof))
+
+	// a proof for other params is rejected
+	p, err = post.NewParams(id, n+1, l, h.Id())
+	assert.NoError(t, err)
+	assert.Error(t, VerifyWithParams(p, post.Labeling{}, challenge, comm, proof))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pv.ProveContext(ctx, challenge)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestVerifierSharded(t *testing.T) {
+
+	currFolder, err := os.Getwd()
+	if err != nil {
+		assert.NoError(t, err, "can't get path of executable")
+	}
+
+	const n, l = uint64(9), uint(4)
+	dir := filepath.Join(currFolder, "shards")
+	assert.NoError(t, os.MkdirAll(dir, 0777))
+	defer os.RemoveAll(dir)
+	mf := filepath.Join(dir, "manifest.json")
+
+	id := util.Rnd(t, 32)
+	h := hashing.NewHashFunc(id)
+
+	m, err := post.NewManifest(n, l, 3, []string{"."})
+	assert.NoError(t, err)
+	tbl, err := post.NewShardedTable(id, n, l, h, post.Labeling{}, m, mf)
+	assert.NoError(t, err)
+	comm, err := tbl.StoreSharded()
+	assert.NoError(t, err)
+
+	pp, err := post.NewParams(id, n, l, h.Id())
+	assert.NoError(t, err)
+	pv, err := prover.NewShardedProver(pp, mf)
+	assert.NoError(t, err)
+
+	challenge := util.Rnd(t, 32)
+	proof, err := pv.Prove(challenge)
+	assert.NoError(t, err)
+
+	err = Verify(id, challenge, comm, n, l, h, proof)
+	assert.NoError(t, err)
+}